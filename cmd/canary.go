@@ -0,0 +1,66 @@
+package cmd
+
+import (
+	"github.com/minus5/pitwall/deploy"
+	"github.com/spf13/cobra"
+)
+
+var canaryCmd = &cobra.Command{
+	Use:   "canary",
+	Short: "Promotes or fails canaries of running Nomad deployment",
+	Long: `Promotes or fails canaries of running Nomad deployment.
+  Deployment id is shown by deploy --canary-hold and status commands.
+
+  Examples:
+    pitwall canary promote 5e1c3a2b -d pg1 --dc s2
+    pitwall canary fail 5e1c3a2b -d pg1 --dc s2`,
+}
+
+var canaryPromoteCmd = &cobra.Command{
+	Use:   "promote <deployment-id>",
+	Short: "Promotes canaries",
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) != 1 {
+			cmd.Usage()
+			return
+		}
+		exit(deploy.PromoteCanaries(canaryOptions(args[0])))
+	},
+}
+
+var canaryFailCmd = &cobra.Command{
+	Use:   "fail <deployment-id>",
+	Short: "Fails deployment",
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) != 1 {
+			cmd.Usage()
+			return
+		}
+		exit(deploy.FailDeployment(canaryOptions(args[0])))
+	},
+}
+
+func canaryOptions(id string) deploy.Options {
+	return deploy.Options{
+		Deployment:        dep,
+		Dc:                dc,
+		NomadDeploymentID: id,
+		Path:              path,
+		NoGit:             noGit,
+		RepoURL:           repoURL,
+		Branch:            branch,
+		Consul:            consul,
+		Output:            output,
+	}
+}
+
+func init() {
+	canaryCmd.AddCommand(canaryPromoteCmd)
+	canaryCmd.AddCommand(canaryFailCmd)
+	rootCmd.AddCommand(canaryCmd)
+
+	canaryCmd.PersistentFlags().StringVarP(&dep, "dep", "d", "", "deployment")
+	canaryCmd.MarkPersistentFlagRequired("dep")
+	canaryCmd.PersistentFlags().StringVar(&dc, "dc", "", "datacenter of the Nomad deployment")
+	canaryCmd.MarkPersistentFlagRequired("dc")
+}
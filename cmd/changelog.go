@@ -0,0 +1,44 @@
+package cmd
+
+import (
+	"github.com/minus5/pitwall/deploy"
+	"github.com/spf13/cobra"
+)
+
+var changelogCmd = &cobra.Command{
+	Use:   "changelog <service> [tag]",
+	Short: "Shows commits between deployed and selected image",
+	Long: `Shows commits between deployed and selected image.
+  If tag is missing the newest image in registry is used.
+  Commits are read from the service source checkout set with --src.
+
+  Examples:
+    pitwall changelog backend_api -d pg1 --src "~/work/{service}"
+    pitwall changelog backend_api -d pg1 --src "~/work/{service}" 20160613151056.99a146a.b8a1fbf.747da38`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) < 1 || len(args) > 2 || src == "" {
+			cmd.Usage()
+			return
+		}
+		tag := ""
+		if len(args) == 2 {
+			tag = args[1]
+		}
+		exit(deploy.Changelog(deploy.Options{
+			Deployment: dep,
+			Service:    args[0],
+			Path:       path,
+			Registry:   registry,
+			RegistryCA: registryCA,
+			Image:      tag,
+			Src:        src,
+		}))
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(changelogCmd)
+
+	changelogCmd.Flags().StringVarP(&dep, "dep", "d", "", "deployment of the service")
+	changelogCmd.MarkFlagRequired("dep")
+}
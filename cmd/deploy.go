@@ -1,18 +1,40 @@
 package cmd
 
 import (
+	"time"
+
 	_ "github.com/minus5/svckit/dcy/lazy"
 
 	"github.com/minus5/pitwall/deploy"
+	"github.com/minus5/pitwall/monit"
 	"github.com/spf13/cobra"
 )
 
 var deployCmd = &cobra.Command{
 	Use:   "deploy <service>",
 	Short: "Deploys service to a deployment",
-	Long:  ``,
+	Long: `Deploys service to a deployment.
+  With --manifest all services listed in release manifest are deployed.
+  Services are deployed after their dependencies (depends_on), services
+  without mutual dependencies are deployed in parallel.
+
+  Manifest example:
+    services:
+      - name: cashier
+        image: 20180613151056.99a146a.b8a1fbf.747da38
+      - name: backend_api
+        image: registry.dev.minus5.hr/backend_api:20180613151211.8e1a7c2.b8a1fbf.747da38
+        depends_on: [cashier]
+
+  Examples:
+    pitwall deploy backend_api -d pg1
+    pitwall deploy backend_api -d pg1 --commit 99a146a
+    pitwall deploy backend_api -d pg1 --since "2 days ago"
+    pitwall deploy backend_api -d pg1 --canary-hold --canary-max-soak 30m
+    pitwall deploy backend_api -d pg1 --canary-window 10m --canary-threshold 0.005
+    pitwall deploy -d pg1 --manifest release.yml`,
 	Run: func(cmd *cobra.Command, args []string) {
-		if len(args) > 1 {
+		if len(args) > 1 || (len(args) == 1 && manifest != "") {
 			cmd.Usage()
 			return
 		}
@@ -20,13 +42,69 @@ var deployCmd = &cobra.Command{
 		if len(args) == 1 {
 			service = args[0]
 		}
-		deploy.Run(dep, service, path, registry, image, noGit, consul)
+		st, err := monit.ParseTime(since)
+		if err != nil {
+			invalidFlag("since", err)
+		}
+		exit(deploy.Run(deploy.Options{
+			Deployment:      dep,
+			Service:         service,
+			Path:            path,
+			Registry:        registry,
+			RegistryCA:      registryCA,
+			Image:           image,
+			NoGit:           noGit,
+			RepoURL:         repoURL,
+			Branch:          branch,
+			ViaBranch:       viaBranch,
+			Consul:          consul,
+			PlanOnly:        planOnly,
+			Manifest:        manifest,
+			AutoRevert:      autoRevert,
+			NonInteractive:  nonInteractive,
+			Output:          output,
+			Commit:          commit,
+			Since:           st,
+			Src:             src,
+			CanaryHold:      canaryHold,
+			CanaryMaxSoak:   canaryMaxSoak,
+			CanaryWindow:    canaryWindow,
+			CanaryThreshold: canaryThreshold,
+			HealthTimeout:   healthTimeout,
+			Timeout:         timeout,
+		}))
 	},
 }
 
+var (
+	planOnly   bool
+	manifest   string
+	autoRevert bool
+	commit     string
+	since      string
+
+	canaryHold      bool
+	canaryMaxSoak   time.Duration
+	canaryWindow    time.Duration
+	canaryThreshold float64
+	healthTimeout   time.Duration
+	timeout         time.Duration
+)
+
 func init() {
 	rootCmd.AddCommand(deployCmd)
 
 	deployCmd.Flags().StringVarP(&dep, "dep", "d", "", "deployment to deploy to")
 	deployCmd.MarkFlagRequired("dep")
+	deployCmd.Flags().BoolVar(&planOnly, "plan-only", false, "show Nomad plan diff and stop before registering job")
+	deployCmd.Flags().StringVar(&manifest, "manifest", "", "release manifest with services and images to deploy together")
+	deployCmd.Flags().BoolVar(&autoRevert, "auto-revert", false, "revert to previously running job version when deployment fails")
+	deployCmd.Flags().StringVar(&commit, "commit", "", "deploy image built from commit (hash prefix)")
+	deployCmd.Flags().StringVar(&since, "since", "", "offer only images created after (see monit grep time patterns)")
+	deployCmd.Flags().BoolVar(&canaryHold, "canary-hold", false, "wait for operator to promote, fail or extend hold when canaries are healthy")
+	deployCmd.Flags().DurationVar(&canaryMaxSoak, "canary-max-soak", 0, "max canary hold time, in non-interactive mode canaries are promoted after it")
+	deployCmd.Flags().DurationVar(&canaryWindow, "canary-window", 0, "compare canary and stable error log rates during window before promoting")
+	deployCmd.Flags().Float64Var(&canaryThreshold, "canary-threshold", 0.01, "allowed canary error rate above stable (fraction of log lines)")
+	deployCmd.Flags().DurationVar(&healthTimeout, "health-timeout", 2*time.Minute, "wait for service consul checks to pass after deploy, 0 disables it")
+	deployCmd.Flags().DurationVar(&timeout, "timeout", 0, "cancel deployment after duration (e.g. 15m), asks to fail, leave or revert the job")
 }
@@ -0,0 +1,45 @@
+package cmd
+
+import (
+	"github.com/minus5/pitwall/deploy"
+	"github.com/spf13/cobra"
+)
+
+var driftCmd = &cobra.Command{
+	Use:   "drift",
+	Short: "Shows differences between deployment config and running Nomad jobs",
+	Long: `Shows differences between deployment config and running Nomad jobs.
+  With --write running image, count, cpu and mem are written to config.yml.
+
+  Examples:
+    pitwall drift -d pg1
+    pitwall drift -d pg1 --write`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) > 0 {
+			cmd.Usage()
+			return
+		}
+		exit(deploy.Drift(deploy.Options{
+			Deployment:     dep,
+			Path:           path,
+			NoGit:          noGit,
+			RepoURL:        repoURL,
+			Branch:         branch,
+			ViaBranch:      viaBranch,
+			Consul:         consul,
+			Write:          writeDrift,
+			NonInteractive: nonInteractive,
+			Output:         output,
+		}))
+	},
+}
+
+var writeDrift bool
+
+func init() {
+	rootCmd.AddCommand(driftCmd)
+
+	driftCmd.Flags().StringVarP(&dep, "dep", "d", "", "deployment to check")
+	driftCmd.MarkFlagRequired("dep")
+	driftCmd.Flags().BoolVar(&writeDrift, "write", false, "write running state to config.yml")
+}
@@ -0,0 +1,39 @@
+package cmd
+
+import (
+	"github.com/minus5/pitwall/deploy"
+	"github.com/spf13/cobra"
+)
+
+var imageCmd = &cobra.Command{
+	Use:   "image",
+	Short: "Docker images in registry",
+}
+
+var imageInspectCmd = &cobra.Command{
+	Use:   "inspect <service> <tag>",
+	Short: "Shows image labels, size and architecture",
+	Long: `Shows image labels, size and architecture.
+  Metadata is cached in user cache directory.
+
+  Examples:
+    pitwall image inspect backend_api 20180613151211.8e1a7c2.b8a1fbf.747da38`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) != 2 {
+			cmd.Usage()
+			return
+		}
+		exit(deploy.Inspect(deploy.Options{
+			Service:    args[0],
+			Image:      args[1],
+			Registry:   registry,
+			RegistryCA: registryCA,
+			Output:     output,
+		}))
+	},
+}
+
+func init() {
+	imageCmd.AddCommand(imageInspectCmd)
+	rootCmd.AddCommand(imageCmd)
+}
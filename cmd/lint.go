@@ -0,0 +1,42 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/minus5/pitwall/deploy"
+	"github.com/minus5/svckit/env"
+	"github.com/minus5/svckit/log"
+	"github.com/spf13/cobra"
+)
+
+var lintCmd = &cobra.Command{
+	Use:   "lint [deployment]",
+	Short: "Validates deployment config.yml against Nomad job files",
+	Long: `Validates deployment config.yml against Nomad job files.
+  If deployment is missing all deployments are checked.
+
+  Examples:
+    pitwall lint
+    pitwall lint pg1`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) > 1 {
+			cmd.Usage()
+			return
+		}
+		problems, err := deploy.Lint(env.ExpandPath(path), registry, args...)
+		if err != nil {
+			log.Fatal(err)
+		}
+		for _, p := range problems {
+			fmt.Println(p)
+		}
+		if len(problems) > 0 {
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(lintCmd)
+}
@@ -0,0 +1,48 @@
+package cmd
+
+import (
+	"github.com/minus5/pitwall/deploy"
+	"github.com/minus5/svckit/log"
+	"github.com/spf13/cobra"
+)
+
+var lockCmd = &cobra.Command{
+	Use:   "lock",
+	Short: "Deployment locks",
+	Long: `Deployment locks.
+  Deploy holds Consul lock for the service in deployment while running.
+
+  Examples:
+    pitwall lock list
+    pitwall lock break pg1 backend_api`,
+}
+
+var lockListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "Lists deployment locks",
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := deploy.PrintLocks(consul); err != nil {
+			log.Fatal(err)
+		}
+	},
+}
+
+var lockBreakCmd = &cobra.Command{
+	Use:   "break <deployment> <service>",
+	Short: "Removes stale deployment lock",
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) != 2 {
+			cmd.Usage()
+			return
+		}
+		if err := deploy.BreakLock(consul, args[0], args[1]); err != nil {
+			log.Fatal(err)
+		}
+	},
+}
+
+func init() {
+	lockCmd.AddCommand(lockListCmd)
+	lockCmd.AddCommand(lockBreakCmd)
+	rootCmd.AddCommand(lockCmd)
+}
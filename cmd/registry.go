@@ -0,0 +1,63 @@
+package cmd
+
+import (
+	"github.com/minus5/pitwall/deploy"
+	"github.com/minus5/pitwall/monit"
+	"github.com/spf13/cobra"
+)
+
+var registryCmd = &cobra.Command{
+	Use:   "registry",
+	Short: "Docker registry maintenance",
+}
+
+var registryPruneCmd = &cobra.Command{
+	Use:   "prune <service>",
+	Short: "Removes old service images from registry",
+	Long: `Removes old service images from registry.
+  Images referenced in any deployments/*/config.yml, the newest --keep images,
+  images created after --older-than and images without build time in tag
+  are kept. Without --force only shows what would be removed.
+
+  Examples:
+    pitwall registry prune backend_api
+    pitwall registry prune backend_api --keep 5 --older-than "14 days ago" --force`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) != 1 {
+			cmd.Usage()
+			return
+		}
+		before, err := monit.ParseTime(olderThan)
+		if err != nil {
+			invalidFlag("older-than", err)
+		}
+		exit(deploy.Prune(deploy.Options{
+			Service:    args[0],
+			Path:       path,
+			Registry:   registry,
+			RegistryCA: registryCA,
+			NoGit:      noGit,
+			RepoURL:    repoURL,
+			Branch:     branch,
+			Output:     output,
+			Keep:       keepImages,
+			Before:     before,
+			Force:      forcePrune,
+		}))
+	},
+}
+
+var (
+	keepImages int
+	olderThan  string
+	forcePrune bool
+)
+
+func init() {
+	registryCmd.AddCommand(registryPruneCmd)
+	rootCmd.AddCommand(registryCmd)
+
+	registryPruneCmd.Flags().IntVar(&keepImages, "keep", 10, "number of newest images to keep")
+	registryPruneCmd.Flags().StringVar(&olderThan, "older-than", "30 days ago", "remove only images created before (see monit grep time patterns)")
+	registryPruneCmd.Flags().BoolVar(&forcePrune, "force", false, "remove images, without it only dry run is made")
+}
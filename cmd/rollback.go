@@ -0,0 +1,47 @@
+package cmd
+
+import (
+	"github.com/minus5/pitwall/deploy"
+	"github.com/spf13/cobra"
+)
+
+var rollbackCmd = &cobra.Command{
+	Use:   "rollback <service>",
+	Short: "Redeploys previous image of the service",
+	Long: `Redeploys previous image of the service.
+  Previous image is found in the git history of the deployment config.yml.
+
+  Examples:
+    pitwall rollback backend_api -d pg1`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) > 1 {
+			cmd.Usage()
+			return
+		}
+		service := ""
+		if len(args) == 1 {
+			service = args[0]
+		}
+		exit(deploy.Rollback(deploy.Options{
+			Deployment:     dep,
+			Service:        service,
+			Path:           path,
+			NoGit:          noGit,
+			RepoURL:        repoURL,
+			Branch:         branch,
+			ViaBranch:      viaBranch,
+			Consul:         consul,
+			NonInteractive: nonInteractive,
+			Output:         output,
+			Timeout:        timeout,
+		}))
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(rollbackCmd)
+
+	rollbackCmd.Flags().StringVarP(&dep, "dep", "d", "", "deployment to roll back in")
+	rollbackCmd.MarkFlagRequired("dep")
+	rollbackCmd.Flags().DurationVar(&timeout, "timeout", 0, "cancel rollback after duration (e.g. 15m)")
+}
@@ -6,20 +6,29 @@ import (
 
 	_ "github.com/minus5/svckit/dcy/lazy"
 
+	"github.com/minus5/pitwall/deploy"
 	"github.com/minus5/svckit/dcy"
 	"github.com/minus5/svckit/log"
 	"github.com/spf13/cobra"
 )
 
 var (
-	path     string
-	registry string
-	dep      string
-	dc       string
-	noGit    bool
-	service  string
-	consul   string
-	image    string
+	path      string
+	registry  string
+	dep       string
+	dc        string
+	noGit     bool
+	service   string
+	consul    string
+	image     string
+	repoURL   string
+	branch    string
+	viaBranch bool
+
+	nonInteractive bool
+	output         string
+	src            string
+	registryCA     string
 )
 
 //var cfgFile string
@@ -57,8 +66,15 @@ func init() {
 
 	rootCmd.PersistentFlags().StringVar(&path, "path", "~/work/pit/infrastructure", "infastructure project path")
 	rootCmd.PersistentFlags().StringVar(&consul, "consul", "http://consul.s2.minus5.hr", "consul url")
-	rootCmd.PersistentFlags().StringVar(&registry, "registry", "registry.dev.minus5.hr", "docker images registry url")
+	rootCmd.PersistentFlags().StringVar(&registry, "registry", "registry.dev.minus5.hr", "docker images registry url, use https:// prefix for TLS")
+	rootCmd.PersistentFlags().StringVar(&registryCA, "registry-ca", "", "custom CA certificate file for registry https")
 	rootCmd.PersistentFlags().BoolVar(&noGit, "no-git", false, "don't pull/push to infrastructure repository")
+	rootCmd.PersistentFlags().StringVar(&repoURL, "repo", "git@github.com:minus5/infrastructure.git", "infrastructure repository url")
+	rootCmd.PersistentFlags().StringVar(&branch, "branch", "master", "infrastructure repository branch")
+	rootCmd.PersistentFlags().BoolVar(&viaBranch, "via-branch", false, "push config change to a new branch for review instead of --branch")
+	rootCmd.PersistentFlags().BoolVar(&nonInteractive, "non-interactive", false, "fail instead of prompting (for CI)")
+	rootCmd.PersistentFlags().StringVar(&output, "output", "text", "output format: text or json")
+	rootCmd.PersistentFlags().StringVar(&src, "src", "", "service source checkout path for changelog, {service} is replaced with service name")
 	rootCmd.PersistentFlags().StringVar(&image, "image", "", "deploy this image instead of selecting from registry")
 	//rootCmd.PersistentFlags().StringVarP(&dc, "dc", "d", "", "datacenter to deploy to")
 }
@@ -89,6 +105,19 @@ func initConfig() {
 	// }
 }
 
+// exit terminates process with deployment error exit code
+func exit(err error) {
+	if err != nil {
+		os.Exit(deploy.ExitCode(err))
+	}
+}
+
+// invalidFlag prints flag value error and exits with validation exit code
+func invalidFlag(name string, err error) {
+	fmt.Printf("invalid --%s: %v\n", name, err)
+	os.Exit(deploy.ExitValidation)
+}
+
 // getServiceAddress returns adress of service
 func getServiceAddress(names ...string) string {
 	if err := dcy.ConnectTo(consul); err != nil {
@@ -0,0 +1,40 @@
+package cmd
+
+import (
+	"github.com/minus5/pitwall/deploy"
+	"github.com/spf13/cobra"
+)
+
+var statusCmd = &cobra.Command{
+	Use:   "status <service>",
+	Short: "Shows service allocations in all datacenters of the deployment",
+	Long: `Shows service allocations in all datacenters of the deployment.
+
+  Examples:
+    pitwall status backend_api -d pg1`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) > 1 {
+			cmd.Usage()
+			return
+		}
+		service := ""
+		if len(args) == 1 {
+			service = args[0]
+		}
+		exit(deploy.Status(deploy.Options{
+			Deployment:     dep,
+			Service:        service,
+			Path:           path,
+			Consul:         consul,
+			NonInteractive: nonInteractive,
+			Output:         output,
+		}))
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(statusCmd)
+
+	statusCmd.Flags().StringVarP(&dep, "dep", "d", "", "deployment of the service")
+	statusCmd.MarkFlagRequired("dep")
+}
@@ -0,0 +1,512 @@
+package deploy
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	units "github.com/docker/go-units"
+	"github.com/hashicorp/nomad/api"
+	nomadStructs "github.com/hashicorp/nomad/nomad/structs"
+	"github.com/manifoldco/promptui"
+	"github.com/minus5/pitwall/monit"
+	"github.com/minus5/svckit/log"
+)
+
+// canaryHoldExtend is how long the hold is extended before asking again
+const canaryHoldExtend = time.Minute
+
+// operator decisions on held canaries
+const (
+	decisionPromote = "promote"
+	decisionFail    = "fail"
+	decisionExtend  = "extend"
+)
+
+// errShutdown is returned when deployment status stopped while waiting
+var errShutdown = errors.New("deployment status stopped")
+
+// logCounter counts service log lines and errors by allocation
+// Log lines without allocation ID are counted by node.
+type logCounter struct {
+	sync.Mutex
+	lines    map[string]int
+	errors   map[string]int
+	perAlloc bool
+	stream   io.Closer
+}
+
+// newLogCounter subscribes to the service log stream
+func newLogCounter(address, service string) (*logCounter, error) {
+	c := &logCounter{
+		lines:  make(map[string]int),
+		errors: make(map[string]int),
+	}
+	stream, err := monit.Subscribe(address, service, c.add)
+	if err != nil {
+		return nil, err
+	}
+	c.stream = stream
+	return c, nil
+}
+
+func (c *logCounter) add(data []byte) error {
+	var l struct {
+		AllocID string `json:"alloc_id"`
+		Alloc   string `json:"alloc"`
+		Node    string `json:"node"`
+		Host    string `json:"host"`
+		Level   string `json:"level"`
+	}
+	if err := json.Unmarshal(data, &l); err != nil {
+		return nil
+	}
+	key := l.AllocID
+	if key == "" {
+		key = l.Alloc
+	}
+	c.Lock()
+	defer c.Unlock()
+	if key != "" {
+		c.perAlloc = true
+	} else {
+		key = l.Node
+		if key == "" {
+			key = l.Host
+		}
+	}
+	c.lines[key]++
+	if l.Level == "error" || l.Level == "fatal" {
+		c.errors[key]++
+	}
+	return nil
+}
+
+// count returns number of log lines and errors logged by allocation or node
+func (c *logCounter) count(key string) (int, int) {
+	if c == nil {
+		return 0, 0
+	}
+	c.Lock()
+	defer c.Unlock()
+	return c.lines[key], c.errors[key]
+}
+
+// byAlloc tells whether log lines carry allocation ID
+func (c *logCounter) byAlloc() bool {
+	if c == nil {
+		return false
+	}
+	c.Lock()
+	defer c.Unlock()
+	return c.perAlloc
+}
+
+func (c *logCounter) close() {
+	if c != nil {
+		c.stream.Close()
+	}
+}
+
+// rates sums log lines and errors of allocations or nodes
+func (c *logCounter) rates(keys []string) errorRate {
+	var r errorRate
+	for _, k := range keys {
+		lines, errors := c.count(k)
+		r.lines += lines
+		r.errors += errors
+	}
+	return r
+}
+
+// errorRate is number of error log lines among all lines
+type errorRate struct {
+	lines  int
+	errors int
+}
+
+func (r errorRate) rate() float64 {
+	if r.lines == 0 {
+		return 0
+	}
+	return float64(r.errors) / float64(r.lines)
+}
+
+func (r errorRate) String() string {
+	return fmt.Sprintf("%.2f%% (%d/%d)", r.rate()*100, r.errors, r.lines)
+}
+
+// compareRates checks that canary error rate is not above stable rate
+// by more than threshold (fraction of log lines).
+func compareRates(canary, stable errorRate, threshold float64) error {
+	if canary.rate() > stable.rate()+threshold {
+		return fmt.Errorf("canary error rate %s exceeds stable %s by more than %.2f%%",
+			canary, stable, threshold*100)
+	}
+	return nil
+}
+
+// analyzeCanaries compares error log rates of canary and stable allocations
+// Logs are counted during canary window. When log lines don't carry
+// allocation ID, nodes running both canary and stable allocations are not
+// used because log lines can't be told apart. Analysis without log lines
+// of canaries or stable allocations is inconclusive. Returns errShutdown if
+// deployment status stopped during the window.
+func (d *Deployer) analyzeCanaries(depID string, shutdownChan chan interface{}) error {
+	if d.logsAddress == "" {
+		return fmt.Errorf("canary analysis: log stream not available")
+	}
+	counter, err := newLogCounter(d.logsAddress, d.service)
+	if err != nil {
+		return fmt.Errorf("canary analysis: %v", err)
+	}
+	defer counter.close()
+	log.S("step", "analysis").S("service", d.service).S("dc", d.cdc).
+		S("window", d.canaryWindow.String()).Info("analyzing canary error rate")
+	if !d.wait(d.canaryWindow, shutdownChan) {
+		return errShutdown
+	}
+	canaryKeys, stableKeys, err := d.canaryKeys(depID, counter.byAlloc())
+	if err != nil {
+		return err
+	}
+	canary := counter.rates(canaryKeys)
+	stable := counter.rates(stableKeys)
+	log.S("step", "analysis").S("service", d.service).S("dc", d.cdc).
+		S("canary", canary.String()).S("stable", stable.String()).
+		Info("canary error rate")
+	if canary.lines == 0 || stable.lines == 0 {
+		return d.inconclusiveAnalysis(canary, stable)
+	}
+	return compareRates(canary, stable, d.canaryThreshold)
+}
+
+// inconclusiveAnalysis asks operator whether to promote canaries when
+// there are no log lines to compare. Fails in non interactive mode.
+func (d *Deployer) inconclusiveAnalysis(canary, stable errorRate) error {
+	err := fmt.Errorf("canary analysis inconclusive: %d canary and %d stable log lines", canary.lines, stable.lines)
+	log.S("step", "analysis").S("service", d.service).S("dc", d.cdc).Error(err)
+	if d.nonInteractive {
+		return err
+	}
+	d.setHolding(true)
+	defer d.setHolding(false)
+	prompt := promptui.Prompt{
+		Label:     "Canary analysis inconclusive, continue",
+		IsConfirm: true,
+	}
+	if _, perr := prompt.Run(); perr != nil {
+		return err
+	}
+	log.S("step", "analysis").S("service", d.service).S("dc", d.cdc).Info("inconclusive analysis accepted")
+	return nil
+}
+
+// canaryKeys finds allocation IDs or node names of running canary and
+// stable allocations of task groups which have canaries
+func (d *Deployer) canaryKeys(depID string, perAlloc bool) ([]string, []string, error) {
+	dep, _, err := d.cli.Deployments().Info(depID, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	groups := make(map[string]bool)
+	canaries := make(map[string]bool)
+	for name, tg := range dep.TaskGroups {
+		if tg.DesiredCanaries > 0 {
+			groups[name] = true
+		}
+		for _, id := range tg.PlacedCanaries {
+			canaries[id] = true
+		}
+	}
+	allocs, _, err := d.cli.Jobs().Allocations(dep.JobID, false, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	names := make(map[string]string)
+	canary := make(map[string]bool)
+	stable := make(map[string]bool)
+	for _, a := range allocs {
+		if a.DesiredStatus != nomadStructs.AllocDesiredStatusRun || !groups[a.TaskGroup] {
+			continue
+		}
+		k := a.ID
+		if !perAlloc {
+			k = d.nodeName(names, a.NodeID)
+		}
+		if canaries[a.ID] {
+			canary[k] = true
+		} else {
+			stable[k] = true
+		}
+	}
+	var cs, ss []string
+	for n := range canary {
+		if !stable[n] {
+			cs = append(cs, n)
+		}
+	}
+	for n := range stable {
+		if !canary[n] {
+			ss = append(ss, n)
+		}
+	}
+	return cs, ss, nil
+}
+
+// holdCanaries waits for operator decision once canaries are healthy
+// Returns true if canaries should be promoted. Hold can be extended until
+// max soak time passes. In non interactive mode canaries are promoted after
+// max soak time if they are still healthy. Returns errShutdown if deployment
+// status stopped during the hold.
+func (d *Deployer) holdCanaries(depID string, shutdownChan chan interface{}) (bool, error) {
+	d.setHolding(true)
+	defer d.setHolding(false)
+	var counter *logCounter
+	if d.logsAddress != "" {
+		c, err := newLogCounter(d.logsAddress, d.service)
+		if err != nil {
+			log.S("service", d.service).Error(err)
+		} else {
+			counter = c
+			defer c.close()
+		}
+	}
+	started := time.Now()
+	log.S("step", "hold").S("service", d.service).S("dc", d.cdc).
+		S("deploymentID", depID).Info("canaries healthy, holding promotion")
+	for {
+		soaked := d.canaryMaxSoak > 0 && time.Since(started) >= d.canaryMaxSoak
+		if !jsonOutput {
+			if err := d.printCanaries(depID, counter, started); err != nil {
+				return false, err
+			}
+		}
+		if d.nonInteractive {
+			if soaked {
+				return d.checkCanaryHealth(depID), nil
+			}
+			if !d.wait(d.canaryMaxSoak-time.Since(started), shutdownChan) {
+				return false, errShutdown
+			}
+			continue
+		}
+		decision, err := selectCanaryDecision(!soaked)
+		if err != nil {
+			return false, err
+		}
+		log.S("step", "hold").S("service", d.service).S("dc", d.cdc).
+			S("decision", decision).Info("canary decision")
+		switch decision {
+		case decisionPromote:
+			return true, nil
+		case decisionFail:
+			return false, nil
+		}
+		extend := canaryHoldExtend
+		if d.canaryMaxSoak > 0 && d.canaryMaxSoak-time.Since(started) < extend {
+			extend = d.canaryMaxSoak - time.Since(started)
+		}
+		if !d.wait(extend, shutdownChan) {
+			return false, errShutdown
+		}
+	}
+}
+
+// wait sleeps for duration, returns false if deployment status stopped
+func (d *Deployer) wait(du time.Duration, shutdownChan chan interface{}) bool {
+	select {
+	case <-time.After(du):
+		return true
+	case <-shutdownChan:
+		return false
+	}
+}
+
+// setHolding marks that operator is deciding on canaries
+// Status progress is not logged meanwhile so it doesn't mess the prompt.
+func (d *Deployer) setHolding(h bool) {
+	var v int32
+	if h {
+		v = 1
+	}
+	atomic.StoreInt32(&d.holding, v)
+	// hold output is printed below progress table, don't redraw over it
+	logMu.Lock()
+	atomic.StoreInt32(&liveLines, 0)
+	logMu.Unlock()
+}
+
+func (d *Deployer) isHolding() bool {
+	return atomic.LoadInt32(&d.holding) == 1
+}
+
+func selectCanaryDecision(canExtend bool) (string, error) {
+	items := []string{decisionPromote, decisionFail}
+	if canExtend {
+		items = append(items, decisionExtend)
+	}
+	prompt := promptui.Select{
+		Label: "Canaries are healthy",
+		Items: items,
+		Templates: &promptui.SelectTemplates{
+			Selected: string([]byte("\033[" + "1A")),
+		},
+	}
+	_, decision, err := prompt.Run()
+	return decision, err
+}
+
+// printCanaries shows canary allocations with error counts since hold started
+func (d *Deployer) printCanaries(depID string, counter *logCounter, started time.Time) error {
+	dep, _, err := d.cli.Deployments().Info(depID, nil)
+	if err != nil {
+		return err
+	}
+	canaries := make(map[string]bool)
+	for _, tg := range dep.TaskGroups {
+		for _, id := range tg.PlacedCanaries {
+			canaries[id] = true
+		}
+	}
+	allocs, _, err := d.cli.Deployments().Allocations(depID, nil)
+	if err != nil {
+		return err
+	}
+	sort.Slice(allocs, func(i, j int) bool { return allocs[i].ID < allocs[j].ID })
+	nodes := make(map[string]string)
+	fmt.Printf("%s %s\n", info(d.cdc), faint(fmt.Sprintf("canaries of %s, held for %s",
+		shortID(depID), units.HumanDuration(time.Since(started)))))
+	perAlloc := counter.byAlloc()
+	rows := [][]string{{"  ALLOC", "NODE", "STATUS", "HEALTHY", "AGE", "RESTARTS", "LOG LINES", "ERRORS"}}
+	if !perAlloc {
+		rows[0][6], rows[0][7] = "NODE LOG LINES", "NODE ERRORS"
+	}
+	warned := make(map[int]bool)
+	for _, a := range allocs {
+		if !canaries[a.ID] {
+			continue
+		}
+		node := d.nodeName(nodes, a.NodeID)
+		key := a.ID
+		if !perAlloc {
+			key = node
+		}
+		lines, errors := counter.count(key)
+		if errors > 0 {
+			warned[len(rows)] = true
+		}
+		rows = append(rows, []string{
+			"  " + shortID(a.ID),
+			node,
+			a.ClientStatus,
+			allocHealth(a),
+			units.HumanDuration(time.Since(time.Unix(0, a.CreateTime))),
+			strconv.FormatUint(restarts(a), 10),
+			strconv.Itoa(lines),
+			strconv.Itoa(errors)})
+	}
+	widths := columnWidths(rows)
+	for i, r := range rows {
+		styles := map[int]func(interface{}) string{}
+		if warned[i] {
+			styles[7] = warn
+		}
+		fmt.Printf("%s\n", tableRow(r, widths, styles, 0))
+	}
+	switch {
+	case counter == nil:
+		fmt.Printf("  %s\n", faint("log stream not available, error counts not shown"))
+	case !perAlloc:
+		fmt.Printf("  %s\n", faint("log lines don't carry allocation ID, counts include all allocations on the node"))
+	}
+	return nil
+}
+
+// allocHealth returns deployment health of the allocation
+func allocHealth(a *api.AllocationListStub) string {
+	if a.DeploymentStatus == nil || a.DeploymentStatus.Healthy == nil {
+		return "unset"
+	}
+	if *a.DeploymentStatus.Healthy {
+		return "healthy"
+	}
+	return "unhealthy"
+}
+
+// PromoteCanaries promotes canaries of the Nomad deployment
+func (w *Worker) PromoteCanaries(depID string) error {
+	d, err := w.nomadDeployer()
+	if err != nil {
+		return err
+	}
+	if depID, err = d.deploymentID(depID); err != nil {
+		return err
+	}
+	if _, _, err := d.cli.Deployments().PromoteAll(depID, nil); err != nil {
+		return err
+	}
+	log.S("step", "promote").S("dc", w.dc).S("deploymentID", depID).Info("canaries promoted")
+	return nil
+}
+
+// FailDeployment marks the Nomad deployment as failed
+// Nomad rolls back the job if auto revert is set in job update stanza.
+func (w *Worker) FailDeployment(depID string) error {
+	d, err := w.nomadDeployer()
+	if err != nil {
+		return err
+	}
+	if depID, err = d.deploymentID(depID); err != nil {
+		return err
+	}
+	if _, _, err := d.cli.Deployments().Fail(depID, nil); err != nil {
+		return err
+	}
+	log.S("step", "fail").S("dc", w.dc).S("deploymentID", depID).Info("deployment failed")
+	return nil
+}
+
+// deploymentID resolves Nomad deployment ID from its prefix
+// Short IDs are shown by deploy and status commands.
+func (d *Deployer) deploymentID(prefix string) (string, error) {
+	deps, _, err := d.cli.Deployments().PrefixList(prefix)
+	if err != nil {
+		return "", err
+	}
+	switch len(deps) {
+	case 0:
+		return "", validationError(fmt.Errorf("deployment %s not found in %s", prefix, d.cdc))
+	case 1:
+		return deps[0].ID, nil
+	}
+	for _, dep := range deps {
+		if dep.ID == prefix {
+			return dep.ID, nil
+		}
+	}
+	return "", validationError(fmt.Errorf("deployment prefix %s matches %d deployments, use longer prefix", prefix, len(deps)))
+}
+
+// nomadDeployer connects to Nomad in the selected datacenter
+func (w *Worker) nomadDeployer() (*Deployer, error) {
+	steps := []func() error{
+		w.pull,
+		w.loadDepConfig,
+	}
+	if err := runSteps(steps); err != nil {
+		return nil, err
+	}
+	address, err := w.nomadAddress(w.dc)
+	if err != nil {
+		return nil, err
+	}
+	d := NewDeployer(w.root, "", "", w.depConfig, address, w.dc, w.deployment)
+	return d, d.connect()
+}
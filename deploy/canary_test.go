@@ -0,0 +1,70 @@
+package deploy
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLogCounter(t *testing.T) {
+	c := &logCounter{
+		lines:  make(map[string]int),
+		errors: make(map[string]int),
+	}
+	c.add([]byte(`{"node": "s2n1", "level": "info", "msg": "started"}`))
+	c.add([]byte(`{"node": "s2n1", "level": "error", "msg": "failed"}`))
+	c.add([]byte(`{"host": "s2n2", "level": "fatal", "msg": "failed"}`))
+	c.add([]byte(`not json`))
+
+	lines, errors := c.count("s2n1")
+	assert.Equal(t, 2, lines)
+	assert.Equal(t, 1, errors)
+	lines, errors = c.count("s2n2")
+	assert.Equal(t, 1, lines)
+	assert.Equal(t, 1, errors)
+
+	var nc *logCounter
+	lines, errors = nc.count("s2n1")
+	assert.Equal(t, 0, lines+errors)
+}
+
+func TestCompareRates(t *testing.T) {
+	stable := errorRate{lines: 1000, errors: 10}
+	assert.Equal(t, 0.01, stable.rate())
+	assert.Equal(t, 0.0, errorRate{}.rate())
+
+	assert.Nil(t, compareRates(errorRate{lines: 100, errors: 1}, stable, 0.01))
+	assert.Nil(t, compareRates(errorRate{lines: 100, errors: 2}, stable, 0.01))
+	assert.NotNil(t, compareRates(errorRate{lines: 100, errors: 3}, stable, 0.01))
+	assert.NotNil(t, compareRates(errorRate{lines: 10, errors: 1}, errorRate{}, 0))
+	assert.Nil(t, compareRates(errorRate{}, stable, 0))
+}
+
+func TestLogCounterRates(t *testing.T) {
+	c := &logCounter{
+		lines:  map[string]int{"s2n1": 10, "s2n2": 20, "s2n3": 30},
+		errors: map[string]int{"s2n1": 1, "s2n3": 3},
+	}
+	r := c.rates([]string{"s2n1", "s2n2"})
+	assert.Equal(t, errorRate{lines: 30, errors: 1}, r)
+}
+
+func TestLogCounterByAlloc(t *testing.T) {
+	c := &logCounter{
+		lines:  make(map[string]int),
+		errors: make(map[string]int),
+	}
+	c.add([]byte(`{"node": "s2n1", "level": "info"}`))
+	assert.False(t, c.byAlloc())
+	c.add([]byte(`{"node": "s2n1", "alloc_id": "3f8a1c2e", "level": "error"}`))
+	c.add([]byte(`{"node": "s2n1", "alloc": "9b7d4e01", "level": "info"}`))
+	assert.True(t, c.byAlloc())
+
+	lines, errors := c.count("3f8a1c2e")
+	assert.Equal(t, 1, lines)
+	assert.Equal(t, 1, errors)
+	lines, _ = c.count("9b7d4e01")
+	assert.Equal(t, 1, lines)
+	lines, _ = c.count("s2n1")
+	assert.Equal(t, 1, lines)
+}
@@ -0,0 +1,148 @@
+package deploy
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	nomadStructs "github.com/hashicorp/nomad/nomad/structs"
+	"github.com/manifoldco/promptui"
+	"github.com/minus5/svckit/log"
+)
+
+// operator decisions on cancelled deployment
+const (
+	decisionLeave  = "leave running"
+	decisionRevert = "revert"
+)
+
+// interruptContext returns context cancelled on Ctrl-C or SIGTERM
+// Second interrupt releases deployment locks and exits immediately.
+// Handler stops when returned cancel is called, after deploy cleanup.
+func (w *Worker) interruptContext() (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	stopped := make(chan struct{})
+	go func() {
+		select {
+		case <-sig:
+			log.Info("interrupted, press Ctrl-C again to exit immediately")
+			cancel()
+		case <-ctx.Done():
+		case <-stopped:
+			return
+		}
+		select {
+		case <-sig:
+		case <-stopped:
+			return
+		}
+		// waits for deferred unlock if it is already releasing locks
+		w.unlock()
+		os.Exit(ExitCancelled)
+	}()
+	return ctx, func() {
+		signal.Stop(sig)
+		close(stopped)
+		cancel()
+	}
+}
+
+// startTimeout limits the rest of the deployment with timeout
+// Steps before it (pull, lock, image selection) are not limited.
+func (w *Worker) startTimeout() error {
+	if w.timeout > 0 {
+		w.ctx, w.stopTimeout = context.WithTimeout(w.ctx, w.timeout)
+	}
+	return nil
+}
+
+// activeDeployment checks if Nomad deployment with status can be failed
+func activeDeployment(status string) bool {
+	return status == nomadStructs.DeploymentStatusRunning || status == nomadStructs.DeploymentStatusPaused
+}
+
+// sleep waits for duration, returns error if context is done before
+func sleep(ctx context.Context, du time.Duration) error {
+	select {
+	case <-time.After(du):
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// cancelDecisions returns decisions offered to operator and the default
+// one. Job can be reverted only if there is a previous job version.
+func (d *Deployer) cancelDecisions() ([]string, string) {
+	items := []string{decisionFail, decisionLeave}
+	if d.prevVersion == nil {
+		return items, decisionFail
+	}
+	items = append(items, decisionRevert)
+	if d.autoRevert {
+		return items, decisionRevert
+	}
+	return items, decisionFail
+}
+
+// cancelled decides what to do with registered job when deployment is
+// cancelled or timed out. Nomad deployment can be failed, left running or
+// job reverted to the previous version. In non interactive mode job is
+// reverted with auto revert, otherwise deployment is failed.
+func (d *Deployer) cancelled(cause error) error {
+	reason := "cancelled"
+	if d.ctx.Err() == context.DeadlineExceeded {
+		reason = "timed out"
+	}
+	items, decision := d.cancelDecisions()
+	if !d.nonInteractive {
+		prompt := promptui.Select{
+			Label: fmt.Sprintf("Deployment %s, what to do with job in %s?", reason, d.cdc),
+			Items: items,
+			Templates: &promptui.SelectTemplates{
+				Selected: string([]byte("\033[" + "1A")),
+			},
+		}
+		_, res, err := prompt.Run()
+		if err == nil {
+			decision = res
+		}
+	}
+	log.S("step", "cancel").S("service", d.service).S("dc", d.cdc).
+		S("deploymentID", d.jobDeploymentID).S("reason", reason).
+		S("decision", decision).Info("deployment " + reason)
+
+	// cleanup must not be stopped by the cancelled context,
+	// second Ctrl-C still exits
+	d.ctx = context.Background()
+	err := fmt.Errorf("deployment %s: %v", reason, cause)
+	switch decision {
+	case decisionLeave:
+		d.leftRunning = true
+		return withCode(ExitCancelled, fmt.Errorf("%v; left running", err))
+	case decisionRevert:
+		return withCode(ExitCancelled, d.revert(err))
+	}
+	if d.jobDeploymentID != "" {
+		// deployment may already be finished, e.g. cancelled during health checks
+		dep, _, ierr := d.cli.Deployments().Info(d.jobDeploymentID, nil)
+		if ierr == nil && !activeDeployment(dep.Status) {
+			log.S("step", "cancel").S("service", d.service).S("dc", d.cdc).
+				S("deploymentID", d.jobDeploymentID).S("status", dep.Status).Info("deployment already finished")
+			// successful job keeps running and is recorded in config.yml
+			d.leftRunning = dep.Status == nomadStructs.DeploymentStatusSuccessful
+			return withCode(ExitCancelled, fmt.Errorf("%v; deployment already %s", err, dep.Status))
+		}
+		if _, _, ferr := d.cli.Deployments().Fail(d.jobDeploymentID, nil); ferr != nil {
+			return withCode(ExitCancelled, fmt.Errorf("%v; failing deployment: %v", err, ferr))
+		}
+	}
+	log.S("step", "cancel").S("service", d.service).S("dc", d.cdc).
+		S("deploymentID", d.jobDeploymentID).Info("deployment failed")
+	return withCode(ExitCancelled, fmt.Errorf("%v; deployment failed", err))
+}
@@ -0,0 +1,45 @@
+package deploy
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSleep(t *testing.T) {
+	assert.Nil(t, sleep(context.Background(), time.Millisecond))
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+	defer cancel()
+	assert.Equal(t, context.DeadlineExceeded, sleep(ctx, time.Minute))
+
+	ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+	assert.Equal(t, context.Canceled, sleep(ctx, time.Minute))
+}
+
+func TestCancelDecisions(t *testing.T) {
+	d := &Deployer{autoRevert: true}
+	items, decision := d.cancelDecisions()
+	assert.Equal(t, []string{decisionFail, decisionLeave}, items)
+	assert.Equal(t, decisionFail, decision)
+
+	v := uint64(3)
+	d.prevVersion = &v
+	items, decision = d.cancelDecisions()
+	assert.Equal(t, []string{decisionFail, decisionLeave, decisionRevert}, items)
+	assert.Equal(t, decisionRevert, decision)
+
+	d.autoRevert = false
+	_, decision = d.cancelDecisions()
+	assert.Equal(t, decisionFail, decision)
+}
+
+func TestActiveDeployment(t *testing.T) {
+	assert.True(t, activeDeployment("running"))
+	assert.True(t, activeDeployment("paused"))
+	assert.False(t, activeDeployment("successful"))
+	assert.False(t, activeDeployment("failed"))
+}
@@ -0,0 +1,136 @@
+package deploy
+
+import (
+	"fmt"
+	"strings"
+
+	"code.gitea.io/git"
+	"github.com/minus5/svckit/env"
+	"github.com/minus5/svckit/log"
+)
+
+// Changelog shows commits between deployed and selected (or the newest) image
+func (w *Worker) Changelog() error {
+	steps := []func() error{
+		w.selectService,
+		w.changelogImage,
+		w.printChangelog,
+	}
+	return runSteps(steps)
+}
+
+// changelogImage selects the newest image when image is not set
+func (w *Worker) changelogImage() error {
+	if w.image != "" {
+		w.image = fullImage(registryHost(w.registryURL), w.service, w.image)
+		return nil
+	}
+	r, err := w.registryClient()
+	if err != nil {
+		return err
+	}
+	i, err := NewImage(r, w.service, w.serviceConfig.Image)
+	if err != nil {
+		return err
+	}
+	if i.Len() == 0 {
+		return fmt.Errorf("no images for service %s", w.service)
+	}
+	w.image = i.First()
+	return nil
+}
+
+// showChangelog shows changelog before deploy
+// Changelog is informative only, errors don't stop the deploy.
+func (w *Worker) showChangelog() error {
+	if w.src == "" {
+		return nil
+	}
+	if err := w.writeChangelog(); err != nil {
+		log.S("src", sourcePath(w.src, w.service)).Error(err)
+	}
+	return nil
+}
+
+// printChangelog shows changelog for changelog command
+func (w *Worker) printChangelog() error {
+	if w.src == "" {
+		return validationError(fmt.Errorf("service source checkout path not set, use --src"))
+	}
+	return w.writeChangelog()
+}
+
+// writeChangelog prints commits between currently deployed and selected image
+// Commits are read from service source checkout, the first commit hash in
+// tag is used. In json output commits are emitted as step events.
+func (w *Worker) writeChangelog() error {
+	src := sourcePath(w.src, w.service)
+	from := NewTag(imageTag(w.serviceConfig.Image), true)
+	to := NewTag(imageTag(w.image), false)
+	commits, reverted, err := changelog(src, from, to)
+	if err != nil {
+		return gitError(err)
+	}
+	if jsonOutput {
+		for _, c := range commits {
+			log.S("step", "changelog").S("service", w.service).S("commit", c).
+				S("reverted", fmt.Sprintf("%v", reverted)).Info("commit")
+		}
+		log.S("step", "changelog").S("service", w.service).S("from", from.tag).S("to", to.tag).
+			I("commits", len(commits)).S("reverted", fmt.Sprintf("%v", reverted)).Info("changelog")
+		return nil
+	}
+	if len(commits) == 0 {
+		fmt.Printf("%s\n", faint("no changes"))
+		return nil
+	}
+	title := fmt.Sprintf("changes %s..%s", from.commits[0], to.commits[0])
+	if reverted {
+		title = fmt.Sprintf("reverting %s..%s", to.commits[0], from.commits[0])
+	}
+	fmt.Printf("%s\n", info(title))
+	for _, c := range commits {
+		if reverted {
+			fmt.Printf("  %s %s\n", warn("-"), c)
+			continue
+		}
+		fmt.Printf("  %s %s\n", success("+"), c)
+	}
+	return nil
+}
+
+// sourcePath expands {service} in source checkout path
+func sourcePath(tmpl, service string) string {
+	return env.ExpandPath(strings.Replace(tmpl, "{service}", service, -1))
+}
+
+// changelog lists commits between tags in git repository src
+// When to is older than from, commits which would be reverted are listed.
+func changelog(src string, from, to Tag) ([]string, bool, error) {
+	if len(from.commits) == 0 {
+		return nil, false, fmt.Errorf("no commit in tag %s", from.tag)
+	}
+	if len(to.commits) == 0 {
+		return nil, false, fmt.Errorf("no commit in tag %s", to.tag)
+	}
+	commits, err := gitLog(src, from.commits[0], to.commits[0])
+	if err != nil || len(commits) > 0 {
+		return commits, false, err
+	}
+	commits, err = gitLog(src, to.commits[0], from.commits[0])
+	return commits, len(commits) > 0, err
+}
+
+func gitLog(src, from, to string) ([]string, error) {
+	out, err := git.NewCommand("log", "--oneline", "--no-decorate", fmt.Sprintf("%s..%s", from, to)).RunInDir(src)
+	if err != nil {
+		return nil, fmt.Errorf("git log %s..%s failed (is source checkout fetched?): %v", from, to, err)
+	}
+	var commits []string
+	for _, l := range strings.Split(strings.TrimSpace(out), "\n") {
+		if l != "" {
+			commits = append(commits, l)
+		}
+	}
+	return commits, nil
+}
@@ -1,6 +1,7 @@
 package deploy
 
 import (
+	"context"
 	"fmt"
 	"time"
 
@@ -22,6 +23,7 @@ type Deployer struct {
 	root            string
 	service         string
 	image           string
+	digest          string // image is pinned to digest if set
 	address         string
 	config          *DeploymentConfig
 	job             *api.Job
@@ -33,6 +35,25 @@ type Deployer struct {
 	dc              string
 	cdc             string // datacenter set in config file for service
 	deployment      string
+	planOnly        bool // stop after plan, don't register job
+	autoRevert      bool // revert to previous job version when deployment fails
+	prevVersion     *uint64
+	registered      bool
+	promoteErr      error
+	failReasons     []string
+	canaryHold      bool          // wait for operator to promote healthy canaries
+	canaryMaxSoak   time.Duration // max canary hold time
+	canaryWindow    time.Duration // canary error rate analysis window, 0 disables it
+	canaryThreshold float64       // allowed canary error rate above stable
+	nonInteractive  bool
+	logsAddress     string        // service log stream (monit) address
+	holding         int32         // set while canaries are held, see setHolding
+	consul          string        // Consul address for service health checks
+	healthTimeout   time.Duration // wait for Consul checks, 0 disables it
+	ctx             context.Context
+	succeeded       bool // Nomad deployment finished successfully
+	leftRunning     bool // new job version left running after failure
+	liveProgress    bool // render in place progress table instead of log lines
 }
 
 // NewDeployer is used to create new deployer
@@ -55,34 +76,148 @@ func NewDeployer(root, service, image string, config *DeploymentConfig, address,
 // plan - dry-run a job update to determine its effects
 // register - register a job to scheduler
 // status - status of the submited job
-func (d *Deployer) Go() error {
+// consulHealth - service instances in Consul are passing
+// In plan only mode register and status are skipped.
+// When context is cancelled after the job is registered operator decides
+// whether to fail, leave or revert the deployment.
+func (d *Deployer) Go(ctx context.Context) error {
+	d.ctx = ctx
 	steps := []func() error{
 		d.loadServiceConfig,
 		d.connect,
 		d.validate,
 		d.plan,
-		d.register,
-		d.status,
 	}
-	return runSteps(steps)
+	if !d.planOnly {
+		steps = append(steps, d.currentVersion, d.register, d.status, d.consulHealth)
+	}
+	err := runSteps(steps)
+	if err != nil && d.registered && ctx.Err() != nil {
+		return d.cancelled(err)
+	}
+	if err != nil && d.autoRevert && d.registered {
+		return d.revert(err)
+	}
+	if err != nil && d.succeeded {
+		// Nomad runs the new job version although health checks failed
+		d.leftRunning = true
+	}
+	return err
+}
+
+// currentVersion remembers job version running before registration
+func (d *Deployer) currentVersion() error {
+	job, _, err := d.cli.Jobs().Info(*d.job.ID, nil)
+	if err != nil {
+		log.S("job", *d.job.ID).Debug("no running job version to revert to")
+		return nil
+	}
+	d.prevVersion = job.Version
+	if d.prevVersion != nil {
+		log.I("version", int(*d.prevVersion)).Debug("running job version")
+	}
+	return nil
+}
+
+// revert registers job version which was running before this deployment
+// and waits for it to become healthy. Returned error contains both
+// deployment failure and revert outcome.
+func (d *Deployer) revert(cause error) error {
+	for _, r := range d.failReasons {
+		log.S("reason", r).Info("deployment failure")
+	}
+	if d.prevVersion == nil {
+		log.Info("no previous job version, not reverting")
+		return cause
+	}
+	v := *d.prevVersion
+	log.S("step", "revert").S("service", d.service).S("dc", d.cdc).
+		I("version", int(v)).S("cause", cause.Error()).Info("reverting job")
+	code := ExitCode(cause)
+	jr, _, err := d.cli.Jobs().Revert(*d.job.ID, v, nil, nil)
+	if err != nil {
+		return withCode(code, fmt.Errorf("%v; revert to version %d failed: %v", cause, v, err))
+	}
+	d.jobEvalID = jr.EvalID
+	d.jobDeploymentID = ""
+	if err := d.getDeploymentID(); err != nil {
+		return withCode(code, fmt.Errorf("%v; revert to version %d failed: %v", cause, v, err))
+	}
+	if err := d.waitRevert(d.jobDeploymentID); err != nil {
+		return withCode(code, fmt.Errorf("%v; revert to version %d failed: %v", cause, v, err))
+	}
+	log.S("step", "revert").S("service", d.service).S("dc", d.cdc).
+		I("version", int(v)).Info("job reverted")
+	return withCode(code, fmt.Errorf("%v; reverted to version %d", cause, v))
+}
+
+// waitRevert waits for the revert deployment to finish
+// Canaries of the reverted version are promoted as soon as they are healthy.
+func (d *Deployer) waitRevert(depID string) error {
+	if depID == "" {
+		return nil
+	}
+	q := &api.QueryOptions{WaitIndex: 1, AllowStale: true, WaitTime: time.Duration(5 * time.Second)}
+	promoted := false
+	for {
+		if err := d.ctx.Err(); err != nil {
+			return err
+		}
+		dep, meta, err := d.cli.Deployments().Info(depID, q)
+		if err != nil {
+			return err
+		}
+		q.WaitIndex = meta.LastIndex
+		switch dep.Status {
+		case nomadStructs.DeploymentStatusSuccessful:
+			return nil
+		case nomadStructs.DeploymentStatusRunning:
+			if !promoted && hasCanaries(dep) && d.checkCanaryHealth(depID) {
+				if _, _, err := d.cli.Deployments().PromoteAll(depID, nil); err != nil {
+					return err
+				}
+				promoted = true
+			}
+		default:
+			d.checkFailedDeployment(depID)
+			return fmt.Errorf("revert deployment status: %s %s", dep.Status, dep.StatusDescription)
+		}
+	}
 }
 
 // checkServiceConfig - does config.yml exists in dc directory
 func (d *Deployer) checkServiceConfig() error {
 	if s := d.config.FindForDc(d.service, d.cdc); s == nil {
-		return fmt.Errorf("service %s not found in datacenter config", d.service)
+		return validationError(fmt.Errorf("service %s not found in datacenter config", d.service))
 	}
 	return nil
 }
 
 // plan envoke the scheduler in a dry-run mode with new jobs or when updating existing jobs to determine what would happen if the job is submitted
 func (d *Deployer) plan() error {
-	jp, _, err := d.cli.Jobs().Plan(d.job, false, nil)
+	jp, _, err := d.cli.Jobs().Plan(d.job, true, nil)
 	if err != nil {
 		return err
 	}
 	d.jobModifyIndex = jp.JobModifyIndex
-	log.I("modifyIndex", int(jp.JobModifyIndex)).Info("job planned")
+	diff := diffTypeNone
+	if jp.Diff != nil {
+		diff = jp.Diff.Type
+	}
+	log.S("step", "plan").S("service", d.service).S("dc", d.cdc).
+		I("modifyIndex", int(jp.JobModifyIndex)).
+		S("diff", diff).
+		I("failedGroups", len(jp.FailedTGAllocs)).
+		Info("job planned")
+	if !jsonOutput {
+		printPlanDiff(jp.Diff)
+		if len(jp.FailedTGAllocs) > 0 {
+			printPlacementFailures(jp.FailedTGAllocs)
+		}
+	}
+	if jp.Warnings != "" {
+		log.S("warnings", jp.Warnings).Info("plan warnings")
+	}
 	return nil
 }
 
@@ -91,6 +226,9 @@ func (d *Deployer) plan() error {
 // JobModifyIndex matches the current Jobs index. If the index is zero, the
 // register only occurs if the job is new
 func (d *Deployer) register() error {
+	if err := d.ctx.Err(); err != nil {
+		return withCode(ExitCancelled, err)
+	}
 	jr, _, err := d.cli.Jobs().EnforceRegister(d.job, d.jobModifyIndex, nil)
 	if err != nil {
 		return err
@@ -102,10 +240,12 @@ func (d *Deployer) register() error {
 	// processed many times, potentially making state updates, without the state of
 	// the evaluation itself being updated.
 	d.jobEvalID = jr.EvalID
+	d.registered = true
 	if err := d.getDeploymentID(); err != nil {
 		return err
 	}
-	log.S("evalID", jr.EvalID).S("deploymentID", d.jobDeploymentID).Info("job registered")
+	log.S("step", "register").S("service", d.service).S("dc", d.cdc).
+		S("evalID", jr.EvalID).S("deploymentID", d.jobDeploymentID).Info("job registered")
 	return nil
 }
 
@@ -123,7 +263,9 @@ func (d *Deployer) getDeploymentID() error {
 		if ev.Status == "complete" && ev.Type != nomadStructs.JobTypeService {
 			return nil
 		}
-		time.Sleep(time.Second)
+		if err := sleep(d.ctx, time.Second); err != nil {
+			return err
+		}
 	}
 }
 
@@ -144,6 +286,10 @@ func (d *Deployer) status() error {
 
 	t := time.Now()
 	q := &api.QueryOptions{WaitIndex: 1, AllowStale: true, WaitTime: time.Duration(5 * time.Second)}
+	var p *progress
+	if d.liveProgress {
+		p = newProgress(d.service, d.cdc)
+	}
 
 	// signal canaryPromote goroutine to exit if it's still runing on return
 	defer func() {
@@ -153,6 +299,9 @@ func (d *Deployer) status() error {
 	}()
 
 	for {
+		if err := d.ctx.Err(); err != nil {
+			return err
+		}
 		dep, meta, err := d.cli.Deployments().Info(depID, q)
 
 		if err != nil {
@@ -170,7 +319,11 @@ func (d *Deployer) status() error {
 				}
 			}
 
-			return fmt.Errorf("deployment failed")
+			d.failReasons = d.checkFailedDeployment(depID)
+			if d.promoteErr != nil {
+				return healthError(fmt.Errorf("deployment failed: canary promotion: %v", d.promoteErr))
+			}
+			return healthError(fmt.Errorf("deployment failed"))
 		default:
 			break
 
@@ -179,32 +332,48 @@ func (d *Deployer) status() error {
 		q.WaitIndex = meta.LastIndex
 		du := fmt.Sprintf("%.2fs", time.Since(t).Seconds())
 		if dep.Status == nomadStructs.DeploymentStatusRunning {
-			for _, v := range dep.TaskGroups {
-				log.S("running", du).
-					//S("group", k).
+			if d.isHolding() {
+				continue
+			}
+			if p != nil {
+				p.render(dep, d.latestEvents(depID, p.nodes))
+				continue
+			}
+			for k, v := range dep.TaskGroups {
+				log.S("step", "health").S("service", d.service).S("dc", d.cdc).
+					S("running", du).
+					S("group", k).
 					I("desired", v.DesiredTotal).
 					I("placed", v.PlacedAllocs).
 					I("healthy", v.HealthyAllocs).
+					I("unhealthy", v.UnhealthyAllocs).
 					Debug("checking status")
 			}
 			continue
 		}
+		if p != nil {
+			p.render(dep, d.latestEvents(depID, p.nodes))
+		}
 		if dep.Status == nomadStructs.DeploymentStatusSuccessful {
-			log.S("after", du).Info("deployment successful")
+			log.S("step", "health").S("service", d.service).S("dc", d.cdc).
+				S("after", du).Info("deployment successful")
+			d.succeeded = true
 			break
 		}
 
-		d.checkFailedDeployment(depID)
+		d.failReasons = d.checkFailedDeployment(depID)
 
-		return fmt.Errorf("deployment failed status: %s %s",
+		return deploymentError(dep, fmt.Errorf("deployment failed status: %s %s",
 			dep.Status,
-			dep.StatusDescription)
+			dep.StatusDescription))
 	}
 	return nil
 }
 
 // find and show deployment error
-func (d *Deployer) checkFailedDeployment(depID string) {
+// Returns found task errors.
+func (d *Deployer) checkFailedDeployment(depID string) []string {
+	var reasons []string
 	al, _, err := d.cli.Deployments().Allocations(depID, nil)
 	if err == nil {
 		for _, a := range al {
@@ -215,22 +384,32 @@ func (d *Deployer) checkFailedDeployment(depID string) {
 						e.ValidationError != "" ||
 						e.SetupError != "" ||
 						e.VaultError != "" {
-						fmt.Printf("%s%s%s%s%s",
-							warn(e.DriverError),
-							warn(e.DownloadError),
-							warn(e.ValidationError),
-							warn(e.SetupError),
-							warn(e.VaultError))
+						if !jsonOutput {
+							fmt.Printf("%s%s%s%s%s",
+								warn(e.DriverError),
+								warn(e.DownloadError),
+								warn(e.ValidationError),
+								warn(e.SetupError),
+								warn(e.VaultError))
+						}
+						reason := e.DriverError + e.DownloadError +
+							e.ValidationError + e.SetupError + e.VaultError
+						log.S("step", "health").S("service", d.service).S("dc", d.cdc).
+							S("alloc", a.ID).S("reason", reason).Debug("task failed")
+						reasons = append(reasons, reason)
 					}
 				}
 			}
 		}
 	}
+	return reasons
 }
 
 // promote canary allocations when all are healthy
+// With canary hold promotion waits for operator decision.
 func (d *Deployer) canaryPromote(depID string, shutdownChan, deploymentChan chan interface{}) {
-	log.S("deploymentID", depID).Info("promoting deployment")
+	log.S("step", "promote").S("service", d.service).S("dc", d.cdc).
+		S("deploymentID", depID).Info("promoting deployment")
 
 	autoPromote := time.Tick(5 * time.Second)
 
@@ -241,12 +420,41 @@ func (d *Deployer) canaryPromote(depID string, shutdownChan, deploymentChan chan
 			if healthy := d.checkCanaryHealth(depID); !healthy {
 				continue
 			}
+			if d.canaryWindow > 0 {
+				err := d.analyzeCanaries(depID, shutdownChan)
+				if err == errShutdown {
+					return
+				}
+				if err != nil {
+					d.promoteErr = err
+					close(deploymentChan)
+					return
+				}
+			}
+			if d.canaryHold {
+				promote, err := d.holdCanaries(depID, shutdownChan)
+				if err == errShutdown {
+					return
+				}
+				if err == nil && !promote {
+					err = fmt.Errorf("canaries not promoted")
+				}
+				if err != nil {
+					d.promoteErr = err
+					close(deploymentChan)
+					return
+				}
+			}
 
 			_, _, err := d.cli.Deployments().PromoteAll(depID, nil)
 			if err != nil {
 				log.Errorf("error while promoting: %v", err)
+				d.promoteErr = err
 				close(deploymentChan)
+				return
 			}
+			log.S("step", "promote").S("service", d.service).S("dc", d.cdc).
+				S("deploymentID", depID).Info("canaries promoted")
 			return
 
 		case <-shutdownChan:
@@ -256,6 +464,28 @@ func (d *Deployer) canaryPromote(depID string, shutdownChan, deploymentChan chan
 
 }
 
+// deploymentError classifies failed deployment
+// Deployment which was unable to place all allocations is placement failure,
+// otherwise allocations were placed but didn't become healthy.
+func deploymentError(dep *api.Deployment, err error) error {
+	for _, tg := range dep.TaskGroups {
+		if tg.PlacedAllocs < tg.DesiredTotal {
+			return placementError(err)
+		}
+	}
+	return healthError(err)
+}
+
+// hasCanaries checks if any task group in deployment requires canaries
+func hasCanaries(dep *api.Deployment) bool {
+	for _, tg := range dep.TaskGroups {
+		if tg.DesiredCanaries > 0 {
+			return true
+		}
+	}
+	return false
+}
+
 // check if all canary allocations are healthy
 func (d *Deployer) checkCanaryHealth(depID string) bool {
 	var unhealthy int
@@ -289,7 +519,7 @@ func (d *Deployer) loadServiceConfig() error {
 		job, err = jobspec.ParseFile(fn)
 	}
 	if err != nil {
-		return err
+		return validationError(err)
 	}
 
 	log.S("from", fn).Debug("loaded config")
@@ -301,21 +531,26 @@ func (d *Deployer) loadServiceConfig() error {
 func (d *Deployer) connect() error {
 	c := &api.Config{}
 	addr := d.address
-	c = c.ClientConfig("", addr, false)
+	n := d.config.NomadFor(d.cdc)
+	c = c.ClientConfig(n.Region, addr, false)
 	cli, err := api.NewClient(c)
 	if err != nil {
 		return err
 	}
-	log.S("nomad", addr).Info("connected")
+	log.S("step", "connect").S("service", d.service).S("dc", d.cdc).
+		S("nomad", addr).Info("connected")
 	d.cli = cli
 	// server default dc and region
 	dc, err := d.cli.Agent().Datacenter()
 	if err != nil {
 		return err
 	}
-	region, err := d.cli.Agent().Region()
-	if err != nil {
-		return err
+	region := n.Region
+	if region == "" {
+		region, err = d.cli.Agent().Region()
+		if err != nil {
+			return err
+		}
 	}
 	d.dc = dc
 	d.region = region
@@ -323,9 +558,18 @@ func (d *Deployer) connect() error {
 }
 
 // validate the job to check is it syntactically correct
-// combines Nomad job file and config.yml for specific datacenter
 func (d *Deployer) validate() error {
+	d.apply()
+	_, _, err := d.cli.Jobs().Validate(d.job, nil)
+	if err != nil {
+		return validationError(err)
+	}
+	log.S("step", "validate").S("service", d.service).S("dc", d.cdc).Info("job validated")
+	return nil
+}
 
+// apply combines Nomad job file and config.yml for specific datacenter
+func (d *Deployer) apply() {
 	d.job.Region = &d.region
 	d.job.AddDatacenter(d.dc)
 
@@ -349,8 +593,11 @@ func (d *Deployer) validate() error {
 
 			for _, ta := range tg.Tasks {
 				if ta.Name == d.service {
-					ta.Config["image"] = d.image
+					ta.Config["image"] = pinImage(d.image, d.digest)
 					s.Image = d.image
+					if d.digest != "" {
+						s.Digest = d.digest
+					}
 					if len(s.Args) > 0 {
 						ta.Config["args"] = s.Args
 					}
@@ -377,11 +624,4 @@ func (d *Deployer) validate() error {
 			}
 		}
 	}
-
-	_, _, err := d.cli.Jobs().Validate(d.job, nil)
-	if err != nil {
-		return err
-	}
-	log.Info("job validated")
-	return nil
 }
@@ -3,25 +3,88 @@ package deploy
 import (
 	"fmt"
 	"io/ioutil"
+	"net"
+	"strconv"
+	"strings"
 
 	"github.com/manifoldco/promptui"
 	"github.com/minus5/svckit/log"
-	yaml "gopkg.in/yaml.v2"
+	yaml "gopkg.in/yaml.v3"
 )
 
 // DeploymentConfig containes parameters for specific deployment
 type DeploymentConfig struct {
-	root         string
-	deployment   string
-	FederatedDcs string `yaml:"federated_dcs"`
-	Datacenters  map[string]*DcConfig
+	root          string
+	deployment    string
+	data          []byte // config.yml content
+	FederatedDcs  string `yaml:"federated_dcs"`
+	Datacenters   map[string]*DcConfig
+	Notifications []*WebhookConfig `yaml:"notifications,omitempty"`
 }
 
 // DcConfig contains parameters for specific datacenter
 type DcConfig struct {
+	Nomad    *NomadConfig              `yaml:"nomad,omitempty"`
 	Services map[string]*ServiceConfig `yaml:"services,omitempty"`
 }
 
+// NomadConfig describes how to find Nomad servers for datacenter
+// Nomad is found in Consul datacenter ConsulDc as service Service with tag Tag,
+// unless static Address (host:port) is set. Region overrides Nomad agent region.
+//
+// Example:
+//
+//	datacenters:
+//	  js:
+//	    nomad:
+//	      consul_dc: s2
+//	      service: nomad-js
+type NomadConfig struct {
+	ConsulDc string `yaml:"consul_dc,omitempty"`
+	Service  string `yaml:"service,omitempty"`
+	Tag      string `yaml:"tag,omitempty"`
+	Address  string `yaml:"address,omitempty"`
+	Region   string `yaml:"region,omitempty"`
+}
+
+// validate checks Nomad config values
+func (n NomadConfig) validate() error {
+	if n.Address != "" {
+		if _, _, err := net.SplitHostPort(n.Address); err != nil {
+			return fmt.Errorf("invalid address %q, expected host:port", n.Address)
+		}
+		return nil
+	}
+	if n.ConsulDc == "" || n.Service == "" || n.Tag == "" {
+		return fmt.Errorf("consul_dc, service and tag are required without address")
+	}
+	for _, v := range []string{n.ConsulDc, n.Service, n.Tag, n.Region} {
+		if strings.ContainsAny(v, " /:") {
+			return fmt.Errorf("invalid value %q", v)
+		}
+	}
+	return nil
+}
+
+// legacyNomad are datacenters whose Nomad was found by convention before
+// nomad config existed. Their config.yml must now set it explicitly.
+var legacyNomad = map[string]NomadConfig{
+	"js": {ConsulDc: "s2", Service: "nomad-js"},
+}
+
+// validateNomad checks Nomad config of datacenter
+// Legacy datacenter without nomad config is an error, defaults would
+// silently resolve a wrong Nomad.
+func (c *DeploymentConfig) validateNomad(dc string) error {
+	if l, ok := legacyNomad[dc]; ok {
+		if d := c.Datacenters[dc]; d == nil || d.Nomad == nil {
+			return fmt.Errorf("nomad config required, add to datacenter %s:\n  nomad:\n    consul_dc: %s\n    service: %s",
+				dc, l.ConsulDc, l.Service)
+		}
+	}
+	return c.NomadFor(dc).validate()
+}
+
 // NewDeploymentConfig creates new config for specific deployment
 func NewDeploymentConfig(root, deployment string) (*DeploymentConfig, error) {
 	c := &DeploymentConfig{
@@ -94,9 +157,62 @@ func (c *DeploymentConfig) FindDatacenters(service string) []string {
 	return dcs
 }
 
+// NomadFor returns Nomad config for datacenter with defaults applied
+func (c *DeploymentConfig) NomadFor(dc string) NomadConfig {
+	n := NomadConfig{}
+	if d, ok := c.Datacenters[dc]; ok && d != nil && d.Nomad != nil {
+		n = *d.Nomad
+	}
+	if n.ConsulDc == "" {
+		n.ConsulDc = dc
+	}
+	if n.Service == "" {
+		n.Service = "nomad"
+	}
+	if n.Tag == "" {
+		n.Tag = "http"
+	}
+	return n
+}
+
 // FileName returns config.yml for dc
 func (c *DeploymentConfig) FileName() string {
-	return fmt.Sprintf("%s/deployments/%s/config.yml", c.root, c.deployment)
+	return fmt.Sprintf("%s/%s", c.root, c.relFileName())
+}
+
+// relFileName returns config.yml path relative to the repository root
+func (c *DeploymentConfig) relFileName() string {
+	return fmt.Sprintf("deployments/%s/config.yml", c.deployment)
+}
+
+// PreviousImage walks config.yml git history and returns the most recent
+// service image which differs from the currently configured one.
+func (c *DeploymentConfig) PreviousImage(r Repo, service string) (string, error) {
+	s := c.Find(service)
+	if s == nil {
+		return "", fmt.Errorf("service %s not found", service)
+	}
+	fn := c.relFileName()
+	revs, err := r.Log(fn)
+	if err != nil {
+		return "", err
+	}
+	for _, rev := range revs {
+		data, err := r.Show(rev, fn)
+		if err != nil {
+			return "", err
+		}
+		hc := &DeploymentConfig{root: c.root, deployment: c.deployment}
+		if err := yaml.Unmarshal(data, hc); err != nil {
+			log.S("rev", rev).Error(err)
+			continue
+		}
+		if hs := hc.Find(service); hs != nil && hs.Image != "" && hs.Image != s.Image {
+			log.S("rev", rev).S("image", hs.Image).Debug("previous image")
+			return hs.Image, nil
+		}
+	}
+	return "", fmt.Errorf("previous image for service %s not found in %s history", service, fn)
 }
 
 func (c *DeploymentConfig) load() error {
@@ -106,10 +222,11 @@ func (c *DeploymentConfig) load() error {
 		log.Error(err)
 		return err
 	}
-	if err := yaml.Unmarshal([]byte(data), c); err != nil {
+	if err := yaml.Unmarshal(data, c); err != nil {
 		log.Error(err)
 		return err
 	}
+	c.data = data
 	log.S("from", fn).Debug("deployment config")
 	return nil
 }
@@ -117,6 +234,7 @@ func (c *DeploymentConfig) load() error {
 // ServiceConfig represent structure for config.yml
 type ServiceConfig struct {
 	Image       string
+	Digest      string            `yaml:"digest,omitempty"`
 	Args        []string          `yaml:"args,omitempty"`
 	Count       int               `yaml:"count,omitempty"`
 	HostGroup   string            `yaml:"hostgroup,omitempty"`
@@ -127,13 +245,65 @@ type ServiceConfig struct {
 	Canary      *int              `yaml:"canary,omitempty"`
 }
 
-// Save changes to config.yml
-func (c *DeploymentConfig) Save() error {
-	fn := c.FileName()
-	buf, err := yaml.Marshal(c)
+// Set changes field of the service config in datacenter
+// Supported fields are image, digest, count, cpu and mem.
+func (c *DeploymentConfig) Set(dc, service, field, value string) error {
+	s := c.FindForDc(service, dc)
+	if s == nil {
+		return fmt.Errorf("service %s not found in datacenter %s", service, dc)
+	}
+	switch field {
+	case "image":
+		s.Image = value
+		return c.edit(dc, service, field, value)
+	case "digest":
+		s.Digest = value
+		if value == "" {
+			// unpinned image, don't leave empty digest in config.yml
+			return c.remove(dc, service, field)
+		}
+		return c.edit(dc, service, field, value)
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return fmt.Errorf("invalid %s value %q", field, value)
+	}
+	switch field {
+	case "count":
+		s.Count = n
+	case "cpu":
+		s.CPU = n
+	case "mem":
+		s.Memory = n
+	default:
+		return fmt.Errorf("unknown field %s", field)
+	}
+	return c.edit(dc, service, field, value)
+}
+
+// edit changes service field value in config.yml content
+func (c *DeploymentConfig) edit(dc, service, field, value string) error {
+	data, err := yamlSet(c.data, value, "datacenters", dc, "services", service, field)
 	if err != nil {
-		log.S("fn", fn).Error(err)
 		return err
 	}
-	return ioutil.WriteFile(fn, buf, 0644)
+	c.data = data
+	return nil
+}
+
+// remove deletes service field from config.yml content
+func (c *DeploymentConfig) remove(dc, service, field string) error {
+	data, err := yamlDelete(c.data, "datacenters", dc, "services", service, field)
+	if err != nil {
+		return err
+	}
+	c.data = data
+	return nil
+}
+
+// Save changes to config.yml
+// Only fields changed with Set are written, comments, key order and
+// the rest of the file are left intact.
+func (c *DeploymentConfig) Save() error {
+	return ioutil.WriteFile(c.FileName(), c.data, 0644)
 }
@@ -0,0 +1,206 @@
+package deploy
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/hashicorp/nomad/api"
+	"github.com/minus5/svckit/log"
+)
+
+// Mismatch is difference between config.yml and running Nomad job
+type Mismatch struct {
+	Service string
+	Dc      string
+	Field   string
+	Config  string
+	Running string
+}
+
+// Drift compares every service in deployment config with the running Nomad job
+// With write option running image, count, cpu and memory are written to config.yml.
+func (w *Worker) Drift() error {
+	steps := []func() error{
+		w.pull,
+		w.loadDepConfig,
+		w.drift,
+	}
+	if w.write {
+		steps = append(steps,
+			w.pullChanges,
+			w.updateDepConfig,
+			w.push,
+		)
+	}
+	return runSteps(steps)
+}
+
+func (w *Worker) drift() error {
+	var all []Mismatch
+	var dcs []string
+	for dc := range w.depConfig.Datacenters {
+		dcs = append(dcs, dc)
+	}
+	sort.Strings(dcs)
+	for _, dc := range dcs {
+		// empty datacenter entry in config.yml has no services
+		if w.depConfig.Datacenters[dc] == nil {
+			continue
+		}
+		var services []string
+		for s := range w.depConfig.Datacenters[dc].Services {
+			services = append(services, s)
+		}
+		sort.Strings(services)
+		address, err := w.nomadAddress(dc)
+		if err != nil {
+			return err
+		}
+		for _, service := range services {
+			s := w.depConfig.FindForDc(service, dc)
+			d := NewDeployer(w.root, service, s.Image, w.depConfig, address, dc, w.deployment)
+			d.digest = s.Digest
+			ms, err := d.drift()
+			if err != nil {
+				return err
+			}
+			all = append(all, ms...)
+		}
+	}
+	if jsonOutput {
+		logDrift(all)
+	} else {
+		printDrift(all)
+	}
+	if w.write {
+		w.writeDrift(all)
+		w.commitMsg = fmt.Sprintf("synced %s config with running jobs", w.deployment)
+	}
+	return nil
+}
+
+// writeDrift records running values as config changes
+// Only image, count, cpu and memory are written back.
+func (w *Worker) writeDrift(ms []Mismatch) {
+	for _, m := range ms {
+		switch m.Field {
+		case "image", "count", "cpu", "mem":
+		default:
+			continue
+		}
+		if m.Field == "image" {
+			image, digest := unpinImage(m.Running)
+			w.changes = append(w.changes, configChange{dc: m.Dc, service: m.Service, field: "image", value: image})
+			if s := w.depConfig.FindForDc(m.Service, m.Dc); digest != "" || (s != nil && s.Digest != "") {
+				w.changes = append(w.changes, configChange{dc: m.Dc, service: m.Service, field: "digest", value: digest})
+			}
+			log.S("service", m.Service).S("dc", m.Dc).S("image", image).S("digest", digest).Info("config updated")
+			continue
+		}
+		w.changes = append(w.changes, configChange{dc: m.Dc, service: m.Service, field: m.Field, value: m.Running})
+		log.S("service", m.Service).S("dc", m.Dc).S(m.Field, m.Running).Info("config updated")
+	}
+}
+
+// drift compares job which deploy would register with the running one
+func (d *Deployer) drift() ([]Mismatch, error) {
+	if err := runSteps([]func() error{d.loadServiceConfig, d.connect}); err != nil {
+		return nil, err
+	}
+	d.apply()
+	d.job.Canonicalize()
+	running, _, err := d.cli.Jobs().Info(*d.job.ID, nil)
+	if err != nil {
+		if !strings.Contains(err.Error(), "404") {
+			return nil, err
+		}
+		return []Mismatch{{Service: d.service, Dc: d.cdc, Field: "job", Config: *d.job.ID, Running: "not found"}}, nil
+	}
+
+	expected := jobState(d.job, d.service)
+	actual := jobState(running, d.service)
+	var fields []string
+	for k := range expected {
+		fields = append(fields, k)
+	}
+	for k := range actual {
+		if _, ok := expected[k]; !ok {
+			fields = append(fields, k)
+		}
+	}
+	sort.Strings(fields)
+	var ms []Mismatch
+	for _, f := range fields {
+		if expected[f] != actual[f] {
+			ms = append(ms, Mismatch{
+				Service: d.service,
+				Dc:      d.cdc,
+				Field:   f,
+				Config:  expected[f],
+				Running: actual[f],
+			})
+		}
+	}
+	return ms, nil
+}
+
+// jobState extracts values of the service task which are set from config.yml
+func jobState(job *api.Job, service string) map[string]string {
+	st := make(map[string]string)
+	var cs []string
+	for _, c := range job.Constraints {
+		cs = append(cs, fmt.Sprintf("%s %s %s", c.LTarget, c.Operand, c.RTarget))
+	}
+	sort.Strings(cs)
+	st["constraints"] = strings.Join(cs, ", ")
+	for _, tg := range job.TaskGroups {
+		if tg.Name == nil || *tg.Name != service {
+			continue
+		}
+		if tg.Count != nil {
+			st["count"] = strconv.Itoa(*tg.Count)
+		}
+		for _, t := range tg.Tasks {
+			if t.Name != service {
+				continue
+			}
+			st["image"] = fmt.Sprintf("%v", t.Config["image"])
+			if t.Resources != nil && t.Resources.CPU != nil {
+				st["cpu"] = strconv.Itoa(*t.Resources.CPU)
+			}
+			if t.Resources != nil && t.Resources.MemoryMB != nil {
+				st["mem"] = strconv.Itoa(*t.Resources.MemoryMB)
+			}
+			for k, v := range t.Env {
+				st["env."+k] = v
+			}
+		}
+	}
+	return st
+}
+
+// logDrift emits mismatches as step events for json output
+func logDrift(ms []Mismatch) {
+	for _, m := range ms {
+		log.S("step", "drift").S("service", m.Service).S("dc", m.Dc).S("field", m.Field).
+			S("config", m.Config).S("running", m.Running).Info("drift")
+	}
+	log.S("step", "drift").I("mismatches", len(ms)).Info("drift checked")
+}
+
+func printDrift(ms []Mismatch) {
+	if len(ms) == 0 {
+		fmt.Printf("%s\n", success("no drift"))
+		return
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "SERVICE\tDC\tFIELD\tCONFIG\tRUNNING\n")
+	for _, m := range ms {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", m.Service, m.Dc, m.Field, m.Config, warn(m.Running))
+	}
+	tw.Flush()
+}
@@ -0,0 +1,98 @@
+package deploy
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	consul "github.com/hashicorp/consul/api"
+	"github.com/minus5/svckit/log"
+)
+
+// consulHealth waits until all service instances in Consul are passing
+// Instances are searched in Consul datacenter of the Nomad cluster. Check is
+// skipped if service is not registered in Consul.
+func (d *Deployer) consulHealth() error {
+	if d.consul == "" || d.healthTimeout == 0 || d.jobDeploymentID == "" {
+		return nil
+	}
+	cli, err := consulClient(d.consul)
+	if err != nil {
+		return err
+	}
+	consulDc := d.config.NomadFor(d.cdc).ConsulDc
+	name := d.service
+	q := &consul.QueryOptions{Datacenter: consulDc, WaitTime: 5 * time.Second}
+	entries, meta, err := cli.Health().Service(name, "", false, q)
+	if err == nil && len(entries) == 0 {
+		name = strings.Replace(d.service, "_", "-", -1)
+		entries, meta, err = cli.Health().Service(name, "", false, q)
+	}
+	if err != nil {
+		return err
+	}
+	if len(entries) == 0 {
+		log.S("service", d.service).S("consulDc", consulDc).Info("service not registered in consul, health not checked")
+		return nil
+	}
+
+	t := time.Now()
+	deadline := t.Add(d.healthTimeout)
+	for {
+		failing := failingChecks(entries)
+		if len(failing) == 0 {
+			log.S("step", "consul").S("service", d.service).S("dc", d.cdc).
+				I("instances", len(entries)).S("after", fmt.Sprintf("%.2fs", time.Since(t).Seconds())).
+				Info("consul checks passing")
+			return nil
+		}
+		if err := d.ctx.Err(); err != nil {
+			return err
+		}
+		if time.Now().After(deadline) {
+			for _, c := range failing {
+				log.S("step", "consul").S("service", d.service).S("dc", d.cdc).
+					S("node", c.Node).S("check", c.Name).S("status", c.Status).
+					S("output", strings.TrimSpace(c.Output)).Info("check failing")
+			}
+			return healthError(fmt.Errorf("consul checks of %s not passing after %s: %s",
+				name, d.healthTimeout, checkNames(failing)))
+		}
+		log.S("step", "consul").S("service", d.service).S("dc", d.cdc).
+			I("instances", len(entries)).I("failing", len(failing)).
+			Debug("waiting for consul checks")
+		q.WaitIndex = meta.LastIndex
+		entries, meta, err = cli.Health().Service(name, "", false, q)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// failingChecks returns checks of all instances which are not passing
+func failingChecks(entries []*consul.ServiceEntry) []*consul.HealthCheck {
+	var failing []*consul.HealthCheck
+	for _, e := range entries {
+		for _, c := range e.Checks {
+			if c.Status != consul.HealthPassing {
+				failing = append(failing, c)
+			}
+		}
+	}
+	return failing
+}
+
+// checkNames lists unique names of checks
+func checkNames(checks []*consul.HealthCheck) string {
+	m := make(map[string]bool)
+	for _, c := range checks {
+		m[fmt.Sprintf("%s on %s", c.Name, c.Node)] = true
+	}
+	var names []string
+	for n := range m {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
@@ -1,10 +1,7 @@
 package deploy
 
 import (
-	"encoding/json"
 	"fmt"
-	"io/ioutil"
-	"net/http"
 	"sort"
 	"strings"
 	"time"
@@ -16,61 +13,106 @@ import (
 
 // Image represents docker image
 type Image struct {
-	registryURL string
-	service     string
-	current     string
-	tags        []Tag
+	registry *Registry
+	service  string
+	current  string
+	tags     []Tag
+	metadata *metadataCache
 }
 
 // NewImage sets image for deploy
-func NewImage(registry, service, current string) (*Image, error) {
+func NewImage(registry *Registry, service, current string) (*Image, error) {
 	i := &Image{
-		registryURL: registry,
-		service:     service,
-		current:     current,
+		registry: registry,
+		service:  service,
+		current:  current,
+		metadata: newMetadataCache(registry),
 	}
 	return i, i.findTags()
 }
 
+// TagFilter limits tags offered for selection
+type TagFilter struct {
+	Commit string    // prefix of any commit in tag
+	Since  time.Time // tags created after
+}
+
+func (f TagFilter) matches(t Tag) bool {
+	if !f.Since.IsZero() && (t.created.IsZero() || t.created.Before(f.Since)) {
+		return false
+	}
+	return f.Commit == "" || t.hasCommit(f.Commit)
+}
+
+// Filter removes tags not matching filter
+func (i *Image) Filter(f TagFilter) {
+	var s tags
+	for _, t := range i.tags {
+		if f.matches(t) {
+			s = append(s, t)
+		}
+	}
+	i.tags = s
+}
+
+// Len returns number of tags
+func (i Image) Len() int {
+	return len(i.tags)
+}
+
+// First returns the newest image
+func (i Image) First() string {
+	return i.name(i.tags[0])
+}
+
+func (i Image) name(t Tag) string {
+	return fmt.Sprintf("%s/%s:%s", i.registry.Host(), i.service, t.tag)
+}
+
 // Select service image
+// Typing in selector filters tags by commit prefix or tag.
 func (i Image) Select() (string, error) {
+	if len(i.tags) == 0 {
+		return "", fmt.Errorf("no images for service %s", i.service)
+	}
+	items := make([]tagItem, len(i.tags))
+	for j, t := range i.tags {
+		items[j] = tagItem{Tag: t, image: &i}
+	}
+	size := 10
+	// metadata of the first page is fetched before it is shown
+	for j := 0; j < len(i.tags) && j < size; j++ {
+		i.metadata.prefetch(i.service, i.tags[j].tag)
+	}
 	prompt := promptui.Select{
-		Items: i.tags,
-		Size:  10,
+		Items: items,
+		Size:  size,
 		Templates: &promptui.SelectTemplates{
 			Selected: string([]byte("\033[" + "1A")),
 			Label:    fmt.Sprintf(`{{ "Select image:" }} {{ "(* current)" | faint }}`),
+			Details:  `{{ .Details | faint }}`,
+		},
+		Searcher: func(input string, idx int) bool {
+			t := i.tags[idx]
+			return t.hasCommit(input) || strings.Contains(t.tag, input)
 		},
 	}
 
 	idx, _, err := prompt.Run()
-	t := i.tags[idx]
-	return fmt.Sprintf("%s/%s:%s", i.registryURL, i.service, t.tag), err
+	if err != nil {
+		return "", err
+	}
+	return i.name(i.tags[idx]), nil
 }
 
 func (i *Image) findTags() error {
-	resp, err := http.Get(fmt.Sprintf("http://%s/v2/%s/tags/list", i.registryURL, i.service))
-	if err != nil {
-		log.Error(err)
-		return err
-	}
-	defer resp.Body.Close()
-	body, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		log.Error(err)
-		return err
-	}
-	data := &struct {
-		Name string
-		Tags []string
-	}{}
-	err = json.Unmarshal(body, data)
+	names, err := i.registry.Tags(i.service)
 	if err != nil {
 		log.Error(err)
 		return err
 	}
 	var s tags
-	for _, t := range data.Tags {
+	for _, t := range names {
 		s = append(s, NewTag(t, strings.Contains(i.current, t)))
 	}
 	sort.Sort(s)
@@ -78,47 +120,106 @@ func (i *Image) findTags() error {
 	return nil
 }
 
+// tagItem is tag in selector with image metadata as details
+type tagItem struct {
+	Tag
+	image *Image
+}
+
+// Details shows image metadata below the selector
+// Metadata not yet cached is fetched in background, so moving through
+// the list is not blocked by the registry.
+func (t tagItem) Details() string {
+	md, err := t.image.metadata.lookup(t.image.service, t.tag)
+	if err != nil {
+		return fmt.Sprintf("metadata not available: %v", err)
+	}
+	if md == nil {
+		return "loading metadata..."
+	}
+	return md.String()
+}
+
 // Tag is docker image tag
+// Tags in form 20160613151056.99a146a.b8a1fbf.747da38 contain build time
+// followed by commit hashes.
 type Tag struct {
 	tag     string
 	created time.Time
+	commits []string
 	current bool
 }
 
+// hasCommit checks if any of the tag commits starts with prefix
+func (t Tag) hasCommit(prefix string) bool {
+	if prefix == "" {
+		return false
+	}
+	for _, c := range t.commits {
+		if strings.HasPrefix(c, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
 func (t Tag) String() string {
 	prefix := " "
 	if t.current {
 		prefix = "*"
 	}
 	if t.created.IsZero() {
-		return fmt.Sprintf("%s %-25s %-15s %s",
+		return fmt.Sprintf("%s %-25s %-15s %-26s %s",
 			prefix,
 			"",
 			"",
+			"",
 			t.tag)
 	}
 
 	h := units.HumanDuration(time.Now().UTC().Sub(t.created))
 	h = h + " ago"
 
-	return fmt.Sprintf("%s %-25s %-15s %s",
+	return fmt.Sprintf("%s %-25s %-15s %-26s %s",
 		prefix,
 		h,
 		t.created.Format("02.01. 15:04"),
+		strings.Join(t.commits, " "),
 		t.tag)
 }
 
 // NewTag is new docker image tag
 func NewTag(t string, current bool) Tag {
 	parts := strings.Split(t, ".")
-	c, _ := time.ParseInLocation("20060102150405", parts[0], time.Local)
+	c, err := time.ParseInLocation("20060102150405", parts[0], time.Local)
+	var commits []string
+	if err == nil {
+		for _, p := range parts[1:] {
+			if isHex(p) {
+				commits = append(commits, p)
+			}
+		}
+	}
 	return Tag{
 		tag:     t,
 		created: c,
+		commits: commits,
 		current: current,
 	}
 }
 
+func isHex(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, r := range s {
+		if !strings.ContainsRune("0123456789abcdef", r) {
+			return false
+		}
+	}
+	return true
+}
+
 type tags []Tag
 
 func (a tags) Len() int {
@@ -0,0 +1,222 @@
+package deploy
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/nomad/api"
+	"github.com/hashicorp/nomad/jobspec"
+	yaml "gopkg.in/yaml.v3"
+)
+
+// Problem is lint finding in deployment config
+type Problem struct {
+	File   string
+	Line   int
+	Column int
+	Msg    string
+}
+
+func (p Problem) String() string {
+	return fmt.Sprintf("%s:%d:%d: %s", p.File, p.Line, p.Column, p.Msg)
+}
+
+var envKeyRe = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// Lint checks deployment configs against Nomad job files
+// When no deployments are given all deployments in root are checked.
+func Lint(root, registry string, deployments ...string) ([]Problem, error) {
+	if len(deployments) == 0 {
+		fns, err := filepath.Glob(filepath.Join(root, "deployments", "*", "config.yml"))
+		if err != nil {
+			return nil, err
+		}
+		for _, fn := range fns {
+			deployments = append(deployments, filepath.Base(filepath.Dir(fn)))
+		}
+	}
+	l := &linter{
+		root:     root,
+		registry: registryHost(registry),
+		jobs:     make(map[string]*api.Job),
+		jobErrs:  make(map[string]error),
+	}
+	for _, dep := range deployments {
+		if err := l.lint(dep); err != nil {
+			return nil, err
+		}
+	}
+	return l.problems, nil
+}
+
+type linter struct {
+	root     string
+	registry string
+	jobs     map[string]*api.Job
+	jobErrs  map[string]error
+	problems []Problem
+	file     string
+	doc      *yaml.Node
+}
+
+func (l *linter) lint(deployment string) error {
+	c := &DeploymentConfig{root: l.root, deployment: deployment}
+	l.file = c.FileName()
+	data, err := ioutil.ReadFile(l.file)
+	if err != nil {
+		return err
+	}
+	l.doc = &yaml.Node{}
+	if err := yaml.Unmarshal(data, l.doc); err != nil {
+		l.report(nil, "invalid yaml: %v", err)
+		return nil
+	}
+	if err := l.doc.Decode(c); err != nil {
+		l.report(nil, "invalid config: %v", err)
+		return nil
+	}
+
+	var dcs []string
+	for dc := range c.Datacenters {
+		dcs = append(dcs, dc)
+	}
+	sort.Strings(dcs)
+	for _, dc := range dcs {
+		if c.Datacenters[dc] == nil {
+			continue
+		}
+		if err := c.validateNomad(dc); err != nil {
+			k, _ := yamlLookup(l.doc, "datacenters", dc, "nomad")
+			l.report(k, "datacenter %s nomad config: %v", dc, err)
+		}
+		services := c.Datacenters[dc].Services
+		var names []string
+		for name := range services {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			l.lintService(dc, name, services[name])
+		}
+	}
+	return nil
+}
+
+func (l *linter) lintService(dc, name string, s *ServiceConfig) {
+	path := []string{"datacenters", dc, "services", name}
+	key := func(p ...string) *yaml.Node {
+		k, _ := yamlLookup(l.doc, append(path, p...)...)
+		return k
+	}
+	value := func(p ...string) *yaml.Node {
+		_, v := yamlLookup(l.doc, append(path, p...)...)
+		return v
+	}
+	if s == nil {
+		l.report(key(), "service %s in datacenter %s has no config", name, dc)
+		return
+	}
+
+	job, err := l.job(name)
+	if err != nil {
+		l.report(key(), "service %s: %v", name, err)
+	} else {
+		l.lintJob(key(), name, job)
+	}
+
+	if s.Image == "" {
+		l.report(key(), "service %s: image not set", name)
+	} else {
+		if l.registry != "" && !strings.HasPrefix(s.Image, l.registry+"/") {
+			l.report(value("image"), "service %s: image %s is not in registry %s", name, s.Image, l.registry)
+		}
+		if !strings.Contains(s.Image[strings.LastIndex(s.Image, "/")+1:], ":") {
+			l.report(value("image"), "service %s: image %s has no tag", name, s.Image)
+		}
+	}
+	set := func(p string) bool {
+		_, _, found := yamlWalk(l.doc, append(path, p)...)
+		return found == len(path)+1
+	}
+	if s.Count < 0 {
+		l.report(value("count"), "service %s: negative count %d", name, s.Count)
+	}
+	if s.Count == 0 && set("count") {
+		l.report(value("count"), "service %s: count 0 stops all allocations", name)
+	}
+	// Nomad rejects job with resources below minimum
+	min := api.MinResources()
+	if s.CPU < 0 {
+		l.report(value("cpu"), "service %s: negative cpu %d", name, s.CPU)
+	} else if (s.CPU > 0 || set("cpu")) && s.CPU < *min.CPU {
+		l.report(value("cpu"), "service %s: cpu %d below Nomad minimum %d", name, s.CPU, *min.CPU)
+	}
+	if s.Memory < 0 {
+		l.report(value("mem"), "service %s: negative mem %d", name, s.Memory)
+	} else if (s.Memory > 0 || set("mem")) && s.Memory < *min.MemoryMB {
+		l.report(value("mem"), "service %s: mem %d below Nomad minimum %d", name, s.Memory, *min.MemoryMB)
+	}
+	if s.Canary != nil {
+		if *s.Canary < 0 {
+			l.report(value("canary"), "service %s: negative canary %d", name, *s.Canary)
+		}
+		if s.Count > 0 && *s.Canary > s.Count {
+			l.report(value("canary"), "service %s: canary %d greater than count %d", name, *s.Canary, s.Count)
+		}
+	}
+	for k := range s.Environment {
+		if !envKeyRe.MatchString(k) {
+			l.report(key("env", k), "service %s: invalid env key %q", name, k)
+		}
+	}
+}
+
+// lintJob checks that task group and task named after the service exist
+func (l *linter) lintJob(pos *yaml.Node, name string, job *api.Job) {
+	for _, tg := range job.TaskGroups {
+		if tg.Name == nil || *tg.Name != name {
+			continue
+		}
+		for _, t := range tg.Tasks {
+			if t.Name == name {
+				return
+			}
+		}
+		l.report(pos, "service %s: task %s not found in group %s of Nomad job", name, name, name)
+		return
+	}
+	l.report(pos, "service %s: task group %s not found in Nomad job", name, name)
+}
+
+// job loads and caches Nomad job file for service
+func (l *linter) job(service string) (*api.Job, error) {
+	if job, ok := l.jobs[service]; ok {
+		return job, l.jobErrs[service]
+	}
+	var job *api.Job
+	err := fmt.Errorf("Nomad job file not found in nomad/service or nomad/system")
+	for _, dir := range []string{"service", "system"} {
+		fn := filepath.Join(l.root, "nomad", dir, service+".nomad")
+		if _, serr := os.Stat(fn); serr != nil {
+			continue
+		}
+		job, err = jobspec.ParseFile(fn)
+		break
+	}
+	l.jobs[service] = job
+	l.jobErrs[service] = err
+	return job, err
+}
+
+func (l *linter) report(n *yaml.Node, format string, args ...interface{}) {
+	p := Problem{File: l.file, Msg: fmt.Sprintf(format, args...)}
+	if n != nil {
+		p.Line, p.Column = n.Line, n.Column
+	}
+	l.problems = append(l.problems, p)
+}
@@ -0,0 +1,79 @@
+package deploy
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLint(t *testing.T) {
+	root, err := ioutil.TempDir("", "pitwall")
+	assert.Nil(t, err)
+	defer os.RemoveAll(root)
+
+	write := func(fn, content string) {
+		fn = filepath.Join(root, fn)
+		assert.Nil(t, os.MkdirAll(filepath.Dir(fn), 0755))
+		assert.Nil(t, ioutil.WriteFile(fn, []byte(content), 0644))
+	}
+	write("nomad/service/backend_api.nomad", `
+job "backend_api" {
+  group "backend_api" {
+    task "backend_api" {
+      driver = "docker"
+    }
+  }
+}`)
+	write("deployments/pg1/config.yml", `datacenters:
+  dc1:
+    services:
+      backend_api:
+        image: registry/backend_api:1
+        count: 0
+        mem: 5
+        env:
+          bad-key: x
+      cashier:
+        image: other/cashier:1
+        count: -1
+`)
+
+	problems, err := Lint(root, "registry")
+	assert.Nil(t, err)
+	var msgs []string
+	for _, p := range problems {
+		msgs = append(msgs, p.Msg)
+	}
+	assert.Equal(t, []string{
+		"service backend_api: count 0 stops all allocations",
+		"service backend_api: mem 5 below Nomad minimum 10",
+		`service backend_api: invalid env key "bad-key"`,
+		"service cashier: Nomad job file not found in nomad/service or nomad/system",
+		"service cashier: image other/cashier:1 is not in registry registry",
+		"service cashier: negative count -1",
+	}, msgs)
+	assert.Equal(t, 6, problems[0].Line)
+	assert.Equal(t, 7, problems[1].Line)
+	assert.Equal(t, 9, problems[2].Line)
+	assert.Equal(t, 10, problems[3].Line)
+	assert.Equal(t, 11, problems[4].Line)
+	assert.Equal(t, 12, problems[5].Line)
+}
+
+func TestValidateNomad(t *testing.T) {
+	c := &DeploymentConfig{Datacenters: map[string]*DcConfig{
+		"s2": {},
+		"js": {},
+	}}
+	assert.Nil(t, c.validateNomad("s2"))
+	err := c.validateNomad("js")
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "service: nomad-js")
+
+	c.Datacenters["js"].Nomad = &NomadConfig{ConsulDc: "s2", Service: "nomad-js"}
+	assert.Nil(t, c.validateNomad("js"))
+	assert.Equal(t, "http", c.NomadFor("js").Tag)
+}
@@ -0,0 +1,223 @@
+package deploy
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"os/user"
+	"strings"
+	"sync"
+	"time"
+
+	units "github.com/docker/go-units"
+	consul "github.com/hashicorp/consul/api"
+	"github.com/minus5/svckit/log"
+)
+
+// lockPrefix is Consul KV folder with deployment locks
+const lockPrefix = "pitwall/locks"
+
+// LockInfo is value of the lock key
+type LockInfo struct {
+	Key     string    `json:"-"`
+	Session string    `json:"-"`
+	Owner   string    `json:"owner"`
+	Host    string    `json:"host"`
+	Started time.Time `json:"started"`
+}
+
+func (i LockInfo) String() string {
+	h := units.HumanDuration(time.Now().Sub(i.Started)) + " ago"
+	return fmt.Sprintf("%s@%s since %s (%s)", i.Owner, i.Host, i.Started.Format("02.01. 15:04"), h)
+}
+
+// Lock is deployment lock of service in deployment
+// Lock is held by Consul session, so it is released when pitwall dies.
+type Lock struct {
+	key  string
+	cli  *consul.Client
+	lock *consul.Lock
+	done chan struct{}
+	mu   sync.Mutex // guards lock, Release is called from signal handler too
+}
+
+// consulClient connects to Consul on address in form http://host:port
+func consulClient(addr string) (*consul.Client, error) {
+	c := consul.DefaultConfig()
+	if u, err := url.Parse(addr); err == nil && u.Host != "" {
+		c.Address = u.Host
+		c.Scheme = u.Scheme
+	} else {
+		c.Address = addr
+	}
+	return consul.NewClient(c)
+}
+
+func lockKey(deployment, service string) string {
+	return fmt.Sprintf("%s/%s/%s", lockPrefix, deployment, service)
+}
+
+// NewLock creates deployment lock for service
+func NewLock(consulAddr, deployment, service string) (*Lock, error) {
+	cli, err := consulClient(consulAddr)
+	if err != nil {
+		return nil, err
+	}
+	return &Lock{
+		key:  lockKey(deployment, service),
+		cli:  cli,
+		done: make(chan struct{}),
+	}, nil
+}
+
+// Acquire tries to acquire lock once
+// When lock is held by someone else returned error shows lock holder.
+func (l *Lock) Acquire() error {
+	info := LockInfo{
+		Owner:   currentUser(),
+		Host:    hostname(),
+		Started: time.Now(),
+	}
+	value, _ := json.Marshal(info)
+	lock, err := l.cli.LockOpts(&consul.LockOptions{
+		Key:          l.key,
+		Value:        value,
+		SessionName:  "pitwall deploy",
+		SessionTTL:   "30s",
+		LockTryOnce:  true,
+		LockWaitTime: time.Second,
+	})
+	if err != nil {
+		return err
+	}
+	lost, err := lock.Lock(nil)
+	if err != nil {
+		return err
+	}
+	if lost == nil {
+		if holder, err := readLock(l.cli, l.key); err == nil && holder != nil {
+			return fmt.Errorf("%s is locked by %s", l.key, holder)
+		}
+		return fmt.Errorf("%s is locked", l.key)
+	}
+	l.lock = lock
+	log.S("key", l.key).Debug("lock acquired")
+	go l.watch(lost)
+	return nil
+}
+
+// watch reports lost lock
+func (l *Lock) watch(lost <-chan struct{}) {
+	select {
+	case <-lost:
+		log.S("key", l.key).Error(fmt.Errorf("deployment lock lost"))
+	case <-l.done:
+	}
+}
+
+// Release releases lock and removes lock key
+func (l *Lock) Release() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.lock == nil {
+		return
+	}
+	close(l.done)
+	if err := l.lock.Unlock(); err != nil {
+		log.S("key", l.key).Error(err)
+	}
+	if err := l.lock.Destroy(); err != nil && err != consul.ErrLockInUse {
+		log.S("key", l.key).Error(err)
+	}
+	l.lock = nil
+	log.S("key", l.key).Debug("lock released")
+}
+
+func readLock(cli *consul.Client, key string) (*LockInfo, error) {
+	p, _, err := cli.KV().Get(key, nil)
+	if err != nil || p == nil {
+		return nil, err
+	}
+	return lockInfo(p), nil
+}
+
+func lockInfo(p *consul.KVPair) *LockInfo {
+	i := &LockInfo{}
+	json.Unmarshal(p.Value, i)
+	i.Key = p.Key
+	i.Session = p.Session
+	return i
+}
+
+// ListLocks returns all deployment locks
+func ListLocks(consulAddr string) ([]*LockInfo, error) {
+	cli, err := consulClient(consulAddr)
+	if err != nil {
+		return nil, err
+	}
+	pairs, _, err := cli.KV().List(lockPrefix, nil)
+	if err != nil {
+		return nil, err
+	}
+	var locks []*LockInfo
+	for _, p := range pairs {
+		locks = append(locks, lockInfo(p))
+	}
+	return locks, nil
+}
+
+// BreakLock removes lock of service in deployment
+// Session holding the lock is destroyed so the lock can't be used any more.
+func BreakLock(consulAddr, deployment, service string) error {
+	cli, err := consulClient(consulAddr)
+	if err != nil {
+		return err
+	}
+	key := lockKey(deployment, service)
+	i, err := readLock(cli, key)
+	if err != nil {
+		return err
+	}
+	if i == nil {
+		return fmt.Errorf("lock %s not found", key)
+	}
+	if i.Session != "" {
+		if _, err := cli.Session().Destroy(i.Session, nil); err != nil {
+			return err
+		}
+	}
+	if _, err := cli.KV().Delete(key, nil); err != nil {
+		return err
+	}
+	log.S("key", key).S("holder", i.String()).Info("lock broken")
+	return nil
+}
+
+// PrintLocks shows all deployment locks
+func PrintLocks(consulAddr string) error {
+	locks, err := ListLocks(consulAddr)
+	if err != nil {
+		return err
+	}
+	for _, i := range locks {
+		state := "held"
+		if i.Session == "" {
+			state = "stale"
+		}
+		fmt.Printf("%-50s %-6s %s\n", strings.TrimPrefix(i.Key, lockPrefix+"/"), state, i)
+	}
+	return nil
+}
+
+func currentUser() string {
+	if u, err := user.Current(); err == nil {
+		return u.Username
+	}
+	return os.Getenv("USER")
+}
+
+func hostname() string {
+	h, _ := os.Hostname()
+	return h
+}
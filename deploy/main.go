@@ -1,10 +1,15 @@
 package deploy
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"os"
 	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/manifoldco/promptui"
 	"github.com/minus5/svckit/dcy"
@@ -16,25 +21,193 @@ import (
 // prikazi koji je trenutni image
 // povezati s deploy-erom
 
+// Options for deployment process
+type Options struct {
+	Deployment string
+	Service    string
+	Path       string
+	Registry   string
+	Image      string
+	NoGit      bool
+	Consul     string
+	PlanOnly   bool
+	Manifest   string
+	AutoRevert bool
+	Write      bool
+	RepoURL    string
+	Branch     string
+	ViaBranch  bool
+	// NonInteractive fails instead of prompting for service or image
+	NonInteractive bool
+	// Output is text (default) or json
+	Output string
+	// Commit selects image built from commit (hash prefix)
+	Commit string
+	// Since offers only images created after
+	Since time.Time
+	// Src is path to service source checkout, {service} is replaced with service name
+	Src string
+	// RegistryCA is custom CA certificate file for registry https
+	RegistryCA string
+	// Keep is number of newest images protected from prune
+	Keep int
+	// Before protects images created after it from prune
+	Before time.Time
+	// Force removes images, prune is dry run without it
+	Force bool
+	// CanaryHold waits for operator decision before promoting healthy canaries
+	CanaryHold bool
+	// CanaryMaxSoak limits how long canaries can be held
+	CanaryMaxSoak time.Duration
+	// CanaryWindow is canary error rate analysis window, 0 disables analysis
+	CanaryWindow time.Duration
+	// CanaryThreshold is allowed canary error rate above stable allocations
+	CanaryThreshold float64
+	// HealthTimeout is how long to wait for Consul checks after deploy, 0 disables it
+	HealthTimeout time.Duration
+	// Timeout cancels deployment after duration, 0 means no timeout
+	Timeout time.Duration
+	// Dc is datacenter for commands working on single Nomad cluster
+	Dc string
+	// NomadDeploymentID is Nomad deployment to promote or fail
+	NomadDeploymentID string
+}
+
+func newWorker(o Options) *Worker {
+	return &Worker{
+		service:     o.Service,
+		root:        env.ExpandPath(o.Path),
+		registryURL: o.Registry,
+		deployment:  o.Deployment,
+		image:       o.Image,
+		noGit:       o.NoGit,
+		consul:      o.Consul,
+		planOnly:    o.PlanOnly,
+		manifest:    o.Manifest,
+		autoRevert:  o.AutoRevert,
+		write:       o.Write,
+		repoURL:     o.RepoURL,
+		branch:      o.Branch,
+		viaBranch:   o.ViaBranch,
+
+		nonInteractive:  o.NonInteractive,
+		tagFilter:       TagFilter{Commit: o.Commit, Since: o.Since},
+		src:             o.Src,
+		registryCA:      o.RegistryCA,
+		keep:            o.Keep,
+		before:          o.Before,
+		force:           o.Force,
+		canaryHold:      o.CanaryHold,
+		canaryMaxSoak:   o.CanaryMaxSoak,
+		canaryWindow:    o.CanaryWindow,
+		dc:              o.Dc,
+		healthTimeout:   o.HealthTimeout,
+		canaryThreshold: o.CanaryThreshold,
+		timeout:         o.Timeout,
+	}
+}
+
 // Run deployment process
-func Run(deployment, service, path, registry, image string, noGit bool, consul string) {
-	l := newTerminalLogger()
+// Returned error has process exit code, see ExitCode.
+func Run(o Options) error {
+	l := newTerminalLogger(o.Output)
 	defer l.Close()
-	w := Worker{
-		service:     service,
-		root:        env.ExpandPath(path),
-		registryURL: registry,
-		deployment:  deployment,
-		image:       image,
-		noGit:       noGit,
-		consul:      consul,
+	w := newWorker(o)
+	started := time.Now()
+	ctx, cancel := w.interruptContext()
+	defer cancel()
+	var err error
+	if w.manifest != "" {
+		err = w.GoManifest(ctx)
+	} else {
+		err = w.Go(ctx)
 	}
+	// summary is the last json event, after the logged error
+	err = done(err)
+	w.printSummary(started, err)
+	return err
+}
+
+// Rollback redeploys previous image of the service
+func Rollback(o Options) error {
+	l := newTerminalLogger(o.Output)
+	defer l.Close()
+	w := newWorker(o)
+	started := time.Now()
+	ctx, cancel := w.interruptContext()
+	defer cancel()
+	err := w.Rollback(ctx)
+	// summary is the last json event, after the logged error
+	err = done(err)
+	w.printSummary(started, err)
+	return err
+}
+
+// Drift shows differences between deployment config and running Nomad jobs
+func Drift(o Options) error {
+	l := newTerminalLogger(o.Output)
+	defer l.Close()
+	w := newWorker(o)
+	return done(w.Drift())
+}
+
+// Changelog shows commits between deployed and selected image
+func Changelog(o Options) error {
+	l := newTerminalLogger(o.Output)
+	defer l.Close()
+	w := newWorker(o)
+	return done(w.Changelog())
+}
+
+// Prune removes unused images of the service from registry
+func Prune(o Options) error {
+	l := newTerminalLogger(o.Output)
+	defer l.Close()
+	w := newWorker(o)
+	return done(w.Prune())
+}
+
+// Inspect shows image metadata from registry
+func Inspect(o Options) error {
+	l := newTerminalLogger(o.Output)
+	defer l.Close()
+	w := newWorker(o)
+	return done(w.Inspect())
+}
+
+// PromoteCanaries promotes canaries of the running Nomad deployment
+func PromoteCanaries(o Options) error {
+	l := newTerminalLogger(o.Output)
+	defer l.Close()
+	w := newWorker(o)
+	return done(w.PromoteCanaries(o.NomadDeploymentID))
+}
+
+// FailDeployment fails the running Nomad deployment
+func FailDeployment(o Options) error {
+	l := newTerminalLogger(o.Output)
+	defer l.Close()
+	w := newWorker(o)
+	return done(w.FailDeployment(o.NomadDeploymentID))
+}
 
-	if err := w.Go(); err != nil {
+// Status shows service allocations in all datacenters
+func Status(o Options) error {
+	l := newTerminalLogger(o.Output)
+	defer l.Close()
+	w := newWorker(o)
+	return done(w.Status())
+}
+
+func done(err error) error {
+	if err != nil {
 		log.Error(err)
-	} else {
+		return err
+	}
+	if !jsonOutput {
 		fmt.Printf("%s %s\n", promptui.IconGood, success("done"))
 	}
+	return nil
 }
 
 // Worker structure for deployment
@@ -44,9 +217,45 @@ type Worker struct {
 	deployment  string
 	service     string
 	image       string
+	digest      string // image manifest digest
 	consul      string
 	consulDc    string
 	noGit       bool
+	repoURL     string
+	branch      string
+	viaBranch   bool // push config change to review branch instead of branch
+	planOnly    bool
+	manifest    string
+	autoRevert  bool
+	write       bool // write running state to config.yml
+	commitMsg   string
+	locks       []*Lock
+	locksMu     sync.Mutex
+	notifier    *notifier
+	started     time.Time
+	oldImage    string
+	nomadDepIDs map[string]string // Nomad deployment ID by datacenter
+	nomadAddrs  map[string]string // Nomad address by datacenter
+	changes     []configChange    // config.yml changes to commit
+
+	nonInteractive  bool
+	tagFilter       TagFilter
+	src             string // service source checkout path
+	registryCA      string
+	registry        *Registry
+	keep            int       // prune: number of newest images to keep
+	before          time.Time // prune: keep images created after
+	force           bool      // prune: remove images, dry run otherwise
+	canaryHold      bool
+	canaryMaxSoak   time.Duration
+	canaryWindow    time.Duration
+	canaryThreshold float64       // allowed canary error rate above stable
+	healthTimeout   time.Duration // wait for Consul checks after deployment, 0 disables it
+	timeout         time.Duration
+	dc              string // datacenter for canary commands
+	ctx             context.Context
+	stopTimeout     context.CancelFunc
+	parallel        bool // deployed in parallel with other services from manifest
 
 	depConfig     *DeploymentConfig
 	serviceConfig *ServiceConfig
@@ -55,18 +264,113 @@ type Worker struct {
 }
 
 // Go starts deployment process
-func (w *Worker) Go() error {
+// In plan only mode process stops after showing Nomad plan.
+// Deployment is cancelled with ctx.
+func (w *Worker) Go(ctx context.Context) error {
+	w.ctx, w.stopTimeout = ctx, func() {}
+	defer w.unlock()
+	defer func() { w.stopTimeout() }()
 	steps := []func() error{
 		w.pull,
 		w.selectService,
+		w.resolveNomad,
+		w.lock,
 		w.selectImage,
+		w.verifyImage,
+		w.showChangelog,
 		//w.confirmSelection,
+		w.startTimeout,
+		w.notifyStart,
+		w.deploy,
+	}
+	if !w.planOnly {
+		steps = append(steps,
+			w.pullChanges,
+			w.updateDepConfig,
+			w.push,
+		)
+	}
+	err := w.recordChanges(runSteps(steps))
+	w.notifyResult(err)
+	return err
+}
+
+// Rollback redeploys image which was deployed before the current one
+func (w *Worker) Rollback(ctx context.Context) error {
+	w.ctx, w.stopTimeout = ctx, func() {}
+	defer w.unlock()
+	defer func() { w.stopTimeout() }()
+	steps := []func() error{
+		w.pull,
+		w.selectService,
+		w.resolveNomad,
+		w.lock,
+		w.selectPreviousImage,
+		w.verifyImage,
+		w.startTimeout,
+		w.notifyStart,
 		w.deploy,
 		w.pullChanges,
 		w.updateDepConfig,
 		w.push,
 	}
-	return runSteps(steps)
+	err := w.recordChanges(runSteps(steps))
+	w.notifyResult(err)
+	return err
+}
+
+// GoManifest deploys all services listed in release manifest
+// Services are deployed in dependency order and all image changes are
+// commited together.
+func (w *Worker) GoManifest(ctx context.Context) error {
+	w.ctx, w.stopTimeout = ctx, func() {}
+	defer w.unlock()
+	defer func() { w.stopTimeout() }()
+	steps := []func() error{
+		w.pull,
+		w.loadDepConfig,
+		w.deployManifest,
+	}
+	if !w.planOnly {
+		steps = append(steps,
+			w.pullChanges,
+			w.updateDepConfig,
+			w.push,
+		)
+	}
+	// changes of succeeded groups and services are recorded on failure
+	return w.recordChanges(runSteps(steps))
+}
+
+// recordChanges commits collected config changes after failed deploy
+// Changes are collected for jobs running the new image: succeeded
+// datacenters or services, deployments left running after cancel or
+// failed health checks. So the repository reflects what runs in Nomad.
+func (w *Worker) recordChanges(err error) error {
+	if err == nil || len(w.changes) == 0 || w.planOnly || ExitCode(err) == ExitGit {
+		return err
+	}
+	log.I("changes", len(w.changes)).Info("deploy failed, committing config changes of jobs running the new image")
+	steps := []func() error{
+		w.pullChanges,
+		w.updateDepConfig,
+		w.push,
+	}
+	if perr := runSteps(steps); perr != nil {
+		return withCode(ExitCode(err), fmt.Errorf("%v; %v", err, perr))
+	}
+	return err
+}
+
+// maxPushAttempts is number of commit attempts when push is rejected
+const maxPushAttempts = 3
+
+// configChange is change of one service field in config.yml
+type configChange struct {
+	dc      string
+	service string
+	field   string
+	value   string
 }
 
 func runSteps(steps []func() error) error {
@@ -83,33 +387,253 @@ func (w *Worker) deploy() error {
 	if len(dcs) == 0 {
 		log.Fatal(fmt.Errorf("datacenters for service %s not set", w.service))
 	}
+	if w.canaryHold && w.nonInteractive && w.canaryMaxSoak == 0 {
+		return validationError(fmt.Errorf("canary hold in non-interactive mode requires max soak time"))
+	}
 	for _, dc := range dcs {
+		if err := w.ctx.Err(); err != nil {
+			return withCode(ExitCancelled, fmt.Errorf("deployment cancelled: %v", err))
+		}
 		log.Info("Deploying service %s to dacenter %s", w.service, dc)
-		// temporary fix until switch is made
-		nomadName := "nomad"
-		ndc := dc // datacenter used to query nomad from consul
-		if ndc == "js" {
-			ndc = "s2"
-			nomadName = "nomad-js"
+		address, err := w.nomadAddress(dc)
+		if err != nil {
+			return err
 		}
-		address := w.getServiceAddressByTag("http", nomadName, ndc)
 		d := NewDeployer(w.root, w.service, w.image, w.depConfig, address, dc, w.deployment)
+		d.digest = w.digest
+		d.planOnly = w.planOnly
+		d.autoRevert = w.autoRevert
+		d.canaryHold = w.canaryHold
+		d.canaryMaxSoak = w.canaryMaxSoak
+		d.canaryWindow = w.canaryWindow
+		d.canaryThreshold = w.canaryThreshold
+		d.nonInteractive = w.nonInteractive
+		d.consul = w.consul
+		d.healthTimeout = w.healthTimeout
+		if w.canaryHold || w.canaryWindow > 0 {
+			d.logsAddress = w.logsAddress(dc)
+		}
+		// live table would be mixed up by parallel manifest deploys
+		d.liveProgress = !jsonOutput && !w.parallel && isTerminal()
 		w.deployer = d
-		if err := d.Go(); err != nil {
+		err = d.Go(w.ctx)
+		if d.jobDeploymentID != "" {
+			if w.nomadDepIDs == nil {
+				w.nomadDepIDs = make(map[string]string)
+			}
+			w.nomadDepIDs[dc] = d.jobDeploymentID
+		}
+		if d.leftRunning {
+			w.recordImage(dc)
+		}
+		if err != nil {
 			return err
 		}
+		if !w.planOnly {
+			w.recordImage(dc)
+		}
+	}
+	return nil
+}
+
+// recordImage records deployed image as config.yml change
+func (w *Worker) recordImage(dc string) {
+	w.changes = append(w.changes, configChange{dc: dc, service: w.service, field: "image", value: w.image})
+	if w.digest != "" || w.serviceConfig.Digest != "" {
+		w.changes = append(w.changes, configChange{dc: dc, service: w.service, field: "digest", value: w.digest})
 	}
+}
+
+// notifyStart notifies deployment webhooks that deployment started
+func (w *Worker) notifyStart() error {
+	if w.planOnly {
+		return nil
+	}
+	w.notifier = newNotifier(w.depConfig.Notifications)
+	w.started = time.Now()
+	w.oldImage = w.serviceConfig.Image
+	w.notifier.send(w.notification(EventStart, nil))
 	return nil
 }
 
+// notifyResult notifies deployment webhooks about deployment outcome
+func (w *Worker) notifyResult(err error) {
+	if w.notifier == nil {
+		return
+	}
+	if err != nil {
+		w.notifier.send(w.notification(EventFailure, err))
+		return
+	}
+	w.notifier.send(w.notification(EventSuccess, nil))
+}
+
+func (w *Worker) notification(event string, err error) Notification {
+	m := Notification{
+		Event:             event,
+		Service:           w.service,
+		Deployment:        w.deployment,
+		Datacenters:       w.depConfig.FindDatacenters(w.service),
+		OldImage:          w.oldImage,
+		NewImage:          w.image,
+		User:              currentUser(),
+		NomadDeploymentID: w.nomadDepIDs,
+	}
+	if event != EventStart {
+		m.Duration = time.Since(w.started).Seconds()
+	}
+	if err != nil {
+		m.FailureReason = err.Error()
+	}
+	return m
+}
+
+// deployManifest deploys manifest groups one after another
+// Services inside group are deployed in parallel. Deployment stops after
+// the first group with failed service.
+func (w *Worker) deployManifest() error {
+	if w.canaryHold {
+		// services in a group are deployed in parallel, prompts would overlap
+		return validationError(fmt.Errorf("canary hold is not supported with manifest"))
+	}
+	m, err := LoadManifest(w.manifest)
+	if err != nil {
+		return validationError(err)
+	}
+	groups, err := m.groups()
+	if err != nil {
+		return validationError(err)
+	}
+	// resolve all services before deploying any of them
+	workers := make(map[string]*Worker)
+	for _, s := range m.Services {
+		svc := w.depConfig.Find(s.Name)
+		if svc == nil {
+			return validationError(fmt.Errorf("service %s not found in deployment %s", s.Name, w.deployment))
+		}
+		if s.Image == "" {
+			return validationError(fmt.Errorf("image for service %s not set", s.Name))
+		}
+		workers[s.Name] = &Worker{
+			root:            w.root,
+			registryURL:     w.registryURL,
+			registryCA:      w.registryCA,
+			deployment:      w.deployment,
+			service:         s.Name,
+			image:           fullImage(registryHost(w.registryURL), s.Name, s.Image),
+			consul:          w.consul,
+			planOnly:        w.planOnly,
+			autoRevert:      w.autoRevert,
+			canaryWindow:    w.canaryWindow,
+			depConfig:       w.depConfig,
+			serviceConfig:   svc,
+			canaryThreshold: w.canaryThreshold,
+			healthTimeout:   w.healthTimeout,
+			parallel:        true,
+			// parallel deploys can't prompt on cancel
+			nonInteractive: true,
+		}
+	}
+	if err := w.resolveNomadFor(m.names()...); err != nil {
+		return err
+	}
+	for _, sw := range workers {
+		sw.nomadAddrs = w.nomadAddrs
+		if err := sw.verifyImage(); err != nil {
+			return fmt.Errorf("service %s: %v", sw.service, err)
+		}
+	}
+	if err := w.lockServices(m.names()...); err != nil {
+		return err
+	}
+	w.startTimeout()
+	for _, sw := range workers {
+		sw.ctx = w.ctx
+	}
+
+	type result struct {
+		sw  *Worker
+		err error
+	}
+	var deployed []string
+	for i, g := range groups {
+		results := make(chan result, len(g))
+		for _, s := range g {
+			sw := workers[s.Name]
+			log.S("service", sw.service).S("image", sw.image).I("group", i+1).Info("deploying")
+			go func() {
+				sw.notifyStart()
+				err := sw.deploy()
+				sw.notifyResult(err)
+				if err != nil {
+					err = withCode(ExitCode(err), fmt.Errorf("service %s: %v", sw.service, err))
+				}
+				results <- result{sw: sw, err: err}
+			}()
+		}
+		var failed error
+		for range g {
+			r := <-results
+			if r.err != nil {
+				log.Error(r.err)
+				if failed == nil {
+					failed = r.err
+				}
+			}
+			// deployments left running after cancel are recorded too
+			if r.err == nil || ExitCode(r.err) == ExitCancelled {
+				w.changes = append(w.changes, r.sw.changes...)
+				deployed = append(deployed, r.sw.service)
+			}
+		}
+		w.commitMsg = fmt.Sprintf("deployed %s to %s", strings.Join(deployed, ", "), w.deployment)
+		if failed != nil {
+			return failed
+		}
+	}
+	w.commitMsg = fmt.Sprintf("deployed %s to %s", strings.Join(m.names(), ", "), w.deployment)
+	return nil
+}
+
+// lock acquires deployment lock for selected service
+func (w *Worker) lock() error {
+	return w.lockServices(w.service)
+}
+
+func (w *Worker) lockServices(services ...string) error {
+	for _, s := range services {
+		l, err := NewLock(w.consul, w.deployment, s)
+		if err != nil {
+			return err
+		}
+		if err := l.Acquire(); err != nil {
+			return err
+		}
+		w.locksMu.Lock()
+		w.locks = append(w.locks, l)
+		w.locksMu.Unlock()
+	}
+	return nil
+}
+
+// unlock releases all acquired deployment locks
+// Called from deferred cleanup and from interrupt handler.
+func (w *Worker) unlock() {
+	w.locksMu.Lock()
+	defer w.locksMu.Unlock()
+	for _, l := range w.locks {
+		l.Release()
+	}
+	w.locks = nil
+}
+
 func (w *Worker) pull() error {
 	if w.noGit {
 		return nil
 	}
-	gitURL := "git@github.com:minus5/infrastructure.git"
-	repo, err := NewRepo(w.root, gitURL)
+	repo, err := NewRepo(w.root, w.repoURL, w.branch)
 	if err != nil {
-		return err
+		return gitError(err)
 	}
 	w.repo = repo
 	return nil
@@ -119,23 +643,84 @@ func (w *Worker) pullChanges() error {
 	if w.noGit {
 		return nil
 	}
-	return w.repo.Pull()
+	return gitError(w.repo.Pull())
 }
 
 func (w *Worker) push() error {
 	if w.noGit {
 		return nil
 	}
-	return w.repo.Commit(fmt.Sprintf("deployed %s to %s", w.service, w.deployment), w.depConfig.FileName())
+	msg := w.commitMsg
+	if msg == "" {
+		msg = fmt.Sprintf("deployed %s to %s", w.service, w.deployment)
+	}
+	if w.viaBranch {
+		return gitError(w.repo.CommitToBranch(w.reviewBranch(), msg, w.depConfig.FileName()))
+	}
+	fn := w.depConfig.FileName()
+	for attempt := 1; ; attempt++ {
+		err := w.repo.Commit(msg, fn)
+		if err != ErrPushRejected {
+			return gitError(err)
+		}
+		if err := w.repo.Uncommit(fn); err != nil {
+			return gitError(err)
+		}
+		if attempt >= maxPushAttempts {
+			w.logUnrecorded()
+			return gitError(fmt.Errorf("unable to push %s, remote keeps changing; gave up after %d attempts", fn, attempt))
+		}
+		log.I("attempt", attempt).Info("push rejected, pulling remote changes")
+		if err := w.repo.Pull(); err != nil {
+			return gitError(err)
+		}
+		if err := w.updateDepConfig(); err != nil {
+			return err
+		}
+	}
 }
 
-func (w *Worker) selectService() error {
+// logUnrecorded reports changes running in Nomad but missing in config.yml
+func (w *Worker) logUnrecorded() {
+	for _, ch := range w.changes {
+		log.S("service", ch.service).S("dc", ch.dc).S(ch.field, ch.value).
+			Error(fmt.Errorf("running in Nomad but not recorded in %s", w.depConfig.relFileName()))
+	}
+}
+
+// reviewBranch is name of the branch for config change review
+// Name is unique, the same image can be deployed again before review.
+func (w *Worker) reviewBranch() string {
+	name := w.service
+	switch {
+	case w.manifest != "":
+		name = "release"
+	case w.write:
+		name = "drift"
+	case w.image != "":
+		name = fmt.Sprintf("%s-%s", w.service, imageTag(w.image))
+	}
+	return fmt.Sprintf("deploy/%s/%s-%s", w.deployment, name, time.Now().Format("20060102150405"))
+}
+
+func (w *Worker) loadDepConfig() error {
 	c, err := NewDeploymentConfig(w.root, w.deployment)
 	if err != nil {
 		return err
 	}
 	w.depConfig = c
+	return nil
+}
+
+func (w *Worker) selectService() error {
+	if err := w.loadDepConfig(); err != nil {
+		return err
+	}
+	c := w.depConfig
 	if w.service == "" {
+		if w.nonInteractive {
+			return validationError(fmt.Errorf("service not set"))
+		}
 		s, err := c.Select()
 		if err != nil {
 			return err
@@ -145,7 +730,7 @@ func (w *Worker) selectService() error {
 	}
 	svc := c.Find(w.service)
 	if svc == nil {
-		return fmt.Errorf("service %s not found", w.service)
+		return validationError(fmt.Errorf("service %s not found", w.service))
 	}
 	w.serviceConfig = svc
 	return nil
@@ -156,13 +741,30 @@ func (w *Worker) selectImage() error {
 		log.S("image", w.image).Info("image preselected with flag")
 		return nil
 	}
-
-	i, err := NewImage(w.registryURL, w.service, w.serviceConfig.Image)
+	r, err := w.registryClient()
+	if err != nil {
+		return err
+	}
+	i, err := NewImage(r, w.service, w.serviceConfig.Image)
 	if err != nil {
-		log.Error(err)
 		return err
 	}
-	image, err := i.Select()
+	i.Filter(w.tagFilter)
+	if i.Len() == 0 {
+		return validationError(fmt.Errorf("no images of service %s match filter", w.service))
+	}
+	var image string
+	switch {
+	case w.tagFilter.Commit != "" && i.Len() == 1:
+		image = i.First()
+		log.S("commit", w.tagFilter.Commit).S("image", image).Info("image found by commit")
+	case w.nonInteractive && w.tagFilter.Commit != "":
+		return validationError(fmt.Errorf("commit %s matches %d images", w.tagFilter.Commit, i.Len()))
+	case w.nonInteractive:
+		return validationError(fmt.Errorf("image not set"))
+	default:
+		image, err = i.Select()
+	}
 	if err != nil {
 		return err
 	}
@@ -171,6 +773,62 @@ func (w *Worker) selectImage() error {
 	return nil
 }
 
+// verifyImage checks that image exists in registry and finds its digest
+// Nomad job is pinned to the digest so the tag can't be moved under it.
+// Images without registry host (Docker Hub) are not verified.
+func (w *Worker) verifyImage() error {
+	host, repository, reference := splitImage(w.image)
+	if host == "" {
+		log.S("image", w.image).Info("image without registry host, digest not verified")
+		return nil
+	}
+	r, err := w.registryClient()
+	if err != nil {
+		return err
+	}
+	if host != r.Host() {
+		if r, err = NewRegistry("https://"+host, w.registryCA); err != nil {
+			return err
+		}
+	}
+	digest, err := r.Digest(repository, reference)
+	if err != nil {
+		return validationError(err)
+	}
+	w.digest = digest
+	log.S("step", "verify").S("image", w.image).S("digest", digest).Info("image verified")
+	return nil
+}
+
+// registryClient creates Docker registry client once
+func (w *Worker) registryClient() (*Registry, error) {
+	if w.registry != nil {
+		return w.registry, nil
+	}
+	r, err := NewRegistry(w.registryURL, w.registryCA)
+	if err != nil {
+		return nil, err
+	}
+	w.registry = r
+	return r, nil
+}
+
+// selectPreviousImage finds previous image of the service in config.yml history
+func (w *Worker) selectPreviousImage() error {
+	repo := w.repo
+	if w.noGit {
+		repo = Repo{root: w.root, branch: w.branch}
+	}
+	image, err := w.depConfig.PreviousImage(repo, w.service)
+	if err != nil {
+		return err
+	}
+	w.image = image
+	w.commitMsg = fmt.Sprintf("rolled back %s in %s to %s", w.service, w.deployment, image)
+	log.S("image", image).Info("previous image found")
+	return nil
+}
+
 func (w *Worker) confirmSelection() error {
 	prompt := promptui.Prompt{
 		Label:   "Continue? ",
@@ -190,7 +848,17 @@ func (w *Worker) confirmSelection() error {
 	return nil
 }
 
+// updateDepConfig applies recorded changes to freshly loaded config.yml
+// Changes pulled from remote are kept, only changed fields are overwritten.
 func (w *Worker) updateDepConfig() error {
+	if err := w.loadDepConfig(); err != nil {
+		return err
+	}
+	for _, ch := range w.changes {
+		if err := w.depConfig.Set(ch.dc, ch.service, ch.field, ch.value); err != nil {
+			return err
+		}
+	}
 	return w.depConfig.Save()
 }
 
@@ -198,12 +866,15 @@ type terminalLogger struct {
 	f *os.File
 }
 
-func newTerminalLogger() *terminalLogger {
+// newTerminalLogger redirects log to terminal and log file
+// With json output only deployment step events are printed, as json lines.
+func newTerminalLogger(output string) *terminalLogger {
 	fn := fmt.Sprintf("%s%s.log", os.TempDir(), env.AppName())
 	f, err := os.Create(fn)
 	if err != nil {
 		log.Fatal(err)
 	}
+	jsonOutput = output == "json"
 	tl := &terminalLogger{f: f}
 	log.SetOutput(tl)
 	log.S("path", fn).Debug("logging to")
@@ -214,9 +885,18 @@ var faint = promptui.Styler(promptui.FGFaint)
 var info = promptui.Styler(promptui.FGBlue)
 var success = promptui.Styler(promptui.FGGreen)
 var warn = promptui.Styler(promptui.FGRed)
+
+// logMu guards lastMsg and keeps lines of parallel deploys and progress
+// table redraws from interleaving
+var logMu sync.Mutex
 var lastMsg = ""
 
 func (l terminalLogger) Write(p []byte) (int, error) {
+	logMu.Lock()
+	defer logMu.Unlock()
+	if jsonOutput {
+		return l.writeJSON(p)
+	}
 	var m map[string]interface{}
 	json.Unmarshal(p, &m)
 	switch m["level"] {
@@ -236,7 +916,7 @@ func (l terminalLogger) Write(p []byte) (int, error) {
 
 	var keys []string
 	for k, _ := range m {
-		if k == "file" || k == "host" || k == "time" || k == "app" || k == "msg" || k == "level" {
+		if k == "file" || k == "host" || k == "time" || k == "app" || k == "msg" || k == "level" || k == "step" {
 			continue
 		}
 		keys = append(keys, k)
@@ -247,6 +927,7 @@ func (l terminalLogger) Write(p []byte) (int, error) {
 		fmt.Printf(faint(fmt.Sprintf(" %s: %v", k, v)))
 	}
 	fmt.Printf("\n")
+	atomic.StoreInt32(&liveLines, 0)
 	l.f.Write(p)
 	return len(p), nil
 }
@@ -260,14 +941,73 @@ func (l *terminalLogger) Close() {
 	l.f.Close()
 }
 
-func (w *Worker) getServiceAddressByTag(tag, name, dc string) string {
+// nomadAddress finds Nomad server for datacenter
+// Addresses are resolved once and cached.
+func (w *Worker) nomadAddress(dc string) (string, error) {
+	if addr, ok := w.nomadAddrs[dc]; ok {
+		return addr, nil
+	}
+	if err := w.depConfig.validateNomad(dc); err != nil {
+		return "", validationError(fmt.Errorf("datacenter %s nomad config: %v", dc, err))
+	}
+	n := w.depConfig.NomadFor(dc)
+	addr := n.Address
+	if addr == "" {
+		var err error
+		addr, err = w.getServiceAddressByTag(n.Tag, n.Service, n.ConsulDc)
+		if err != nil {
+			return "", err
+		}
+	}
+	if w.nomadAddrs == nil {
+		w.nomadAddrs = make(map[string]string)
+	}
+	w.nomadAddrs[dc] = addr
+	log.S("dc", dc).S("nomad", addr).Debug("nomad found")
+	return addr, nil
+}
+
+// logsAddress finds service log stream (monit) in datacenter
+// Empty address is returned if log stream is not available.
+func (w *Worker) logsAddress(dc string) string {
 	if err := dcy.ConnectTo(w.consul); err != nil {
-		log.Fatal(err)
+		log.Error(err)
+		return ""
 	}
-	addr, err := dcy.ServiceInDcByTag(tag, name, dc)
-	if err == nil {
-		return addr.String()
+	consulDc := w.depConfig.NomadFor(dc).ConsulDc
+	for _, name := range []string{"nsq_notifier", "nsq-notifier"} {
+		if addr, err := dcy.ServiceInDc(name, consulDc); err == nil {
+			return addr.String()
+		}
 	}
-	log.Fatal(fmt.Errorf("service %s with tag %s not found in consul %s", name, tag, w.consul))
+	log.S("dc", consulDc).Info("log stream not found")
 	return ""
 }
+
+// resolveNomad finds Nomad servers for all service datacenters
+// before anything is deployed.
+func (w *Worker) resolveNomad() error {
+	return w.resolveNomadFor(w.service)
+}
+
+func (w *Worker) resolveNomadFor(services ...string) error {
+	for _, s := range services {
+		for _, dc := range w.depConfig.FindDatacenters(s) {
+			if _, err := w.nomadAddress(dc); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (w *Worker) getServiceAddressByTag(tag, name, dc string) (string, error) {
+	if err := dcy.ConnectTo(w.consul); err != nil {
+		return "", err
+	}
+	addr, err := dcy.ServiceInDcByTag(tag, name, dc)
+	if err != nil {
+		return "", fmt.Errorf("service %s with tag %s not found in consul %s datacenter %s", name, tag, w.consul, dc)
+	}
+	return addr.String(), nil
+}
@@ -11,8 +11,28 @@ func TestTag(t *testing.T) {
 	tag := NewTag("20160613151056.99a146a.b8a1fbf.747da38", false)
 	assert.False(t, tag.created.IsZero())
 	assert.Equal(t, "2016-06-13T15:10:56+02:00", tag.created.Format(time.RFC3339))
+	assert.Equal(t, []string{"99a146a", "b8a1fbf", "747da38"}, tag.commits)
+	assert.True(t, tag.hasCommit("b8a1"))
+	assert.False(t, tag.hasCommit("c0ff"))
 
 	tag = NewTag("pero", true)
 	assert.True(t, tag.created.IsZero())
+	assert.Nil(t, tag.commits)
+}
+
+func TestTagFilter(t *testing.T) {
+	since, _ := time.ParseInLocation("20060102", "20160613", time.Local)
+	i := &Image{
+		registry: &Registry{host: "registry"},
+		service:  "backend_api",
+		tags: tags{
+			NewTag("20160614151056.99a146a.b8a1fbf.747da38", false),
+			NewTag("20160612151056.99a146a.c8a1fbf.747da38", false),
+			NewTag("20160611151056.19a146a.b8a1fbf.747da38", false),
+		},
+	}
+	i.Filter(TagFilter{Commit: "99a1", Since: since})
+	assert.Equal(t, 1, i.Len())
+	assert.Equal(t, "registry/backend_api:20160614151056.99a146a.b8a1fbf.747da38", i.First())
 
 }
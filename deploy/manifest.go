@@ -0,0 +1,125 @@
+package deploy
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/minus5/svckit/log"
+	yaml "gopkg.in/yaml.v3"
+)
+
+// Manifest lists services which are released together
+type Manifest struct {
+	Services []*ManifestService `yaml:"services"`
+}
+
+// ManifestService is service and its image in release manifest
+// Service is deployed after all services listed in DependsOn.
+type ManifestService struct {
+	Name      string   `yaml:"name"`
+	Image     string   `yaml:"image"`
+	DependsOn []string `yaml:"depends_on,omitempty"`
+}
+
+// LoadManifest reads release manifest from file
+func LoadManifest(fn string) (*Manifest, error) {
+	data, err := ioutil.ReadFile(fn)
+	if err != nil {
+		return nil, err
+	}
+	m := &Manifest{}
+	if err := yaml.Unmarshal(data, m); err != nil {
+		return nil, err
+	}
+	if len(m.Services) == 0 {
+		return nil, fmt.Errorf("no services in manifest %s", fn)
+	}
+	log.S("from", fn).I("services", len(m.Services)).Debug("release manifest")
+	return m, nil
+}
+
+// names returns service names in manifest order
+func (m *Manifest) names() []string {
+	var names []string
+	for _, s := range m.Services {
+		names = append(names, s.Name)
+	}
+	return names
+}
+
+// groups orders services into deployment groups
+// Each service is placed into the first group after all of its dependencies.
+// Services in the same group don't depend on each other.
+func (m *Manifest) groups() ([][]*ManifestService, error) {
+	services := make(map[string]*ManifestService)
+	for _, s := range m.Services {
+		if s.Name == "" {
+			return nil, fmt.Errorf("service without name in manifest")
+		}
+		if _, ok := services[s.Name]; ok {
+			return nil, fmt.Errorf("service %s listed more than once", s.Name)
+		}
+		services[s.Name] = s
+	}
+	for _, s := range m.Services {
+		for _, d := range s.DependsOn {
+			if _, ok := services[d]; !ok {
+				return nil, fmt.Errorf("service %s depends on %s which is not in manifest", s.Name, d)
+			}
+		}
+	}
+
+	var groups [][]*ManifestService
+	placed := make(map[string]bool)
+	for len(placed) < len(m.Services) {
+		var group []*ManifestService
+		for _, s := range m.Services {
+			if placed[s.Name] {
+				continue
+			}
+			ready := true
+			for _, d := range s.DependsOn {
+				if !placed[d] {
+					ready = false
+					break
+				}
+			}
+			if ready {
+				group = append(group, s)
+			}
+		}
+		if len(group) == 0 {
+			var left []string
+			for _, s := range m.Services {
+				if !placed[s.Name] {
+					left = append(left, s.Name)
+				}
+			}
+			return nil, fmt.Errorf("dependency cycle between services %s", strings.Join(left, ", "))
+		}
+		for _, s := range group {
+			placed[s.Name] = true
+		}
+		groups = append(groups, group)
+	}
+	return groups, nil
+}
+
+// fullImage expands image tag to registry/service:tag
+// Images which already contain repository are returned unchanged.
+func fullImage(registry, service, image string) string {
+	if strings.Contains(image, "/") || strings.Contains(image, ":") {
+		return image
+	}
+	return fmt.Sprintf("%s/%s:%s", registry, service, image)
+}
+
+// imageTag returns tag part of the image name
+func imageTag(image string) string {
+	i := strings.LastIndex(image, ":")
+	if i < 0 || i < strings.LastIndex(image, "/") {
+		return "latest"
+	}
+	return image[i+1:]
+}
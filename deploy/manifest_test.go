@@ -0,0 +1,44 @@
+package deploy
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestManifestGroups(t *testing.T) {
+	m := &Manifest{
+		Services: []*ManifestService{
+			{Name: "backend_api", DependsOn: []string{"cashier"}},
+			{Name: "cashier"},
+			{Name: "frontend", DependsOn: []string{"backend_api"}},
+			{Name: "notifier"},
+		},
+	}
+	groups, err := m.groups()
+	assert.Nil(t, err)
+	var names [][]string
+	for _, g := range groups {
+		var n []string
+		for _, s := range g {
+			n = append(n, s.Name)
+		}
+		names = append(names, n)
+	}
+	assert.Equal(t, [][]string{{"cashier", "notifier"}, {"backend_api"}, {"frontend"}}, names)
+
+	m.Services[1].DependsOn = []string{"frontend"}
+	_, err = m.groups()
+	assert.NotNil(t, err)
+
+	m.Services[1].DependsOn = []string{"unknown"}
+	_, err = m.groups()
+	assert.NotNil(t, err)
+}
+
+func TestFullImage(t *testing.T) {
+	assert.Equal(t, "registry/backend_api:20180101", fullImage("registry", "backend_api", "20180101"))
+	assert.Equal(t, "other/backend_api:1", fullImage("registry", "backend_api", "other/backend_api:1"))
+	assert.Equal(t, "20180101", imageTag("registry:5000/backend_api:20180101"))
+	assert.Equal(t, "latest", imageTag("registry:5000/backend_api"))
+}
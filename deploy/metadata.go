@@ -0,0 +1,287 @@
+package deploy
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	units "github.com/docker/go-units"
+	"github.com/minus5/svckit/log"
+)
+
+// ImageMetadata is image information from registry manifest and config blob
+type ImageMetadata struct {
+	Image        string            `json:"image"`
+	Digest       string            `json:"digest"`
+	Size         int64             `json:"size"` // compressed size of all layers
+	Architecture string            `json:"architecture"`
+	OS           string            `json:"os"`
+	Created      time.Time         `json:"created"`
+	Labels       map[string]string `json:"labels,omitempty"`
+}
+
+// manifest is Docker v2 schema 2 or OCI manifest or manifest list
+type manifest struct {
+	MediaType string `json:"mediaType"`
+	Config    struct {
+		Digest string `json:"digest"`
+	} `json:"config"`
+	Layers []struct {
+		Size int64 `json:"size"`
+	} `json:"layers"`
+	Manifests []struct {
+		Digest   string `json:"digest"`
+		Platform struct {
+			Architecture string `json:"architecture"`
+			OS           string `json:"os"`
+		} `json:"platform"`
+	} `json:"manifests"`
+}
+
+// Metadata reads image manifest and config blob
+// For multi platform images linux/amd64 image is used.
+func (r *Registry) Metadata(repository, reference string) (*ImageMetadata, error) {
+	m, digest, err := r.manifest(repository, reference)
+	if err != nil {
+		return nil, err
+	}
+	if len(m.Manifests) > 0 {
+		ref := m.Manifests[0].Digest
+		for _, p := range m.Manifests {
+			if p.Platform.OS == "linux" && p.Platform.Architecture == "amd64" {
+				ref = p.Digest
+				break
+			}
+		}
+		if m, _, err = r.manifest(repository, ref); err != nil {
+			return nil, err
+		}
+	}
+	if m.Config.Digest == "" {
+		return nil, fmt.Errorf("registry %s: unsupported manifest %s for %s:%s", r.host, m.MediaType, repository, reference)
+	}
+	rsp, err := r.get(fmt.Sprintf("/v2/%s/blobs/%s", repository, m.Config.Digest), repository)
+	if err != nil {
+		return nil, err
+	}
+	defer rsp.Body.Close()
+	cfg := struct {
+		Architecture string    `json:"architecture"`
+		OS           string    `json:"os"`
+		Created      time.Time `json:"created"`
+		Config       struct {
+			Labels map[string]string `json:"Labels"`
+		} `json:"config"`
+	}{}
+	if err := json.NewDecoder(rsp.Body).Decode(&cfg); err != nil {
+		return nil, fmt.Errorf("registry %s: invalid image config: %v", r.host, err)
+	}
+	md := &ImageMetadata{
+		Image:        fmt.Sprintf("%s/%s:%s", r.host, repository, reference),
+		Digest:       digest,
+		Architecture: cfg.Architecture,
+		OS:           cfg.OS,
+		Created:      cfg.Created,
+		Labels:       cfg.Config.Labels,
+	}
+	for _, l := range m.Layers {
+		md.Size += l.Size
+	}
+	return md, nil
+}
+
+// manifest gets image manifest and its digest
+func (r *Registry) manifest(repository, reference string) (*manifest, string, error) {
+	header := http.Header{"Accept": manifestMediaTypes}
+	rsp, err := r.do("GET", fmt.Sprintf("/v2/%s/manifests/%s", repository, reference), repository, header)
+	if err != nil {
+		return nil, "", err
+	}
+	defer rsp.Body.Close()
+	m := &manifest{}
+	if err := json.NewDecoder(rsp.Body).Decode(m); err != nil {
+		return nil, "", fmt.Errorf("registry %s: invalid manifest: %v", r.host, err)
+	}
+	return m, rsp.Header.Get("Docker-Content-Digest"), nil
+}
+
+// metadataCache keeps image metadata in memory and in user cache directory
+// Tags are expected to be immutable, only latest is always fetched.
+type metadataCache struct {
+	registry *Registry
+	dir      string
+	sync.Mutex
+	items   map[string]*ImageMetadata
+	pending map[string]bool  // fetched in background
+	errs    map[string]error // background fetch errors
+}
+
+func newMetadataCache(r *Registry) *metadataCache {
+	c := &metadataCache{
+		registry: r,
+		items:    make(map[string]*ImageMetadata),
+		pending:  make(map[string]bool),
+		errs:     make(map[string]error),
+	}
+	if dir, err := os.UserCacheDir(); err == nil {
+		c.dir = filepath.Join(dir, "pitwall", "images", r.Host())
+	}
+	return c
+}
+
+// get returns cached metadata or fetches it from registry
+func (c *metadataCache) get(repository, tag string) (*ImageMetadata, error) {
+	if md := c.cached(repository, tag); md != nil {
+		return md, nil
+	}
+	md, err := c.registry.Metadata(repository, tag)
+	if err != nil {
+		return nil, err
+	}
+	c.set(repository+":"+tag, md)
+	if fn := c.fileName(repository, tag); fn != "" {
+		c.write(fn, md)
+	}
+	return md, nil
+}
+
+// cached returns metadata from memory or cache directory, nil if not found
+func (c *metadataCache) cached(repository, tag string) *ImageMetadata {
+	key := repository + ":" + tag
+	c.Lock()
+	md, ok := c.items[key]
+	c.Unlock()
+	if ok {
+		return md
+	}
+	if fn := c.fileName(repository, tag); fn != "" {
+		if md := c.read(fn); md != nil {
+			c.set(key, md)
+			return md
+		}
+	}
+	return nil
+}
+
+// lookup returns cached metadata or error of the background fetch
+// Missing metadata is fetched in background, nil is returned meanwhile.
+func (c *metadataCache) lookup(repository, tag string) (*ImageMetadata, error) {
+	if md := c.cached(repository, tag); md != nil {
+		return md, nil
+	}
+	c.Lock()
+	err := c.errs[repository+":"+tag]
+	c.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	c.prefetch(repository, tag)
+	return nil, nil
+}
+
+// prefetch fetches metadata in background if it is not cached
+func (c *metadataCache) prefetch(repository, tag string) {
+	key := repository + ":" + tag
+	c.Lock()
+	_, ok := c.items[key]
+	if ok || c.pending[key] || c.errs[key] != nil {
+		c.Unlock()
+		return
+	}
+	c.pending[key] = true
+	c.Unlock()
+	go func() {
+		_, err := c.get(repository, tag)
+		c.Lock()
+		defer c.Unlock()
+		delete(c.pending, key)
+		if err != nil {
+			c.errs[key] = err
+		}
+	}()
+}
+
+// fileName returns metadata file in cache directory
+// Empty name is returned for latest tag, which is never cached on disk.
+func (c *metadataCache) fileName(repository, tag string) string {
+	if c.dir == "" || tag == "latest" {
+		return ""
+	}
+	return filepath.Join(c.dir, repository, tag+".json")
+}
+
+func (c *metadataCache) set(key string, md *ImageMetadata) {
+	c.Lock()
+	defer c.Unlock()
+	c.items[key] = md
+}
+
+func (c *metadataCache) read(fn string) *ImageMetadata {
+	buf, err := ioutil.ReadFile(fn)
+	if err != nil {
+		return nil
+	}
+	md := &ImageMetadata{}
+	if err := json.Unmarshal(buf, md); err != nil {
+		return nil
+	}
+	return md
+}
+
+func (c *metadataCache) write(fn string, md *ImageMetadata) {
+	buf, _ := json.Marshal(md)
+	if err := os.MkdirAll(filepath.Dir(fn), 0755); err != nil {
+		log.S("dir", filepath.Dir(fn)).Error(err)
+		return
+	}
+	if err := ioutil.WriteFile(fn, buf, 0644); err != nil {
+		log.S("file", fn).Error(err)
+	}
+}
+
+// String formats metadata for selector details and inspect command
+func (md *ImageMetadata) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%-14s %s\n", "digest:", md.Digest)
+	fmt.Fprintf(&b, "%-14s %s/%s\n", "platform:", md.OS, md.Architecture)
+	fmt.Fprintf(&b, "%-14s %s\n", "size:", units.HumanSize(float64(md.Size)))
+	if !md.Created.IsZero() {
+		fmt.Fprintf(&b, "%-14s %s\n", "created:", md.Created.Local().Format("02.01.2006 15:04:05"))
+	}
+	var keys []string
+	for k := range md.Labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fmt.Fprintf(&b, "%-14s %s=%s\n", "label:", k, md.Labels[k])
+	}
+	return b.String()
+}
+
+// Inspect shows image metadata
+func (w *Worker) Inspect() error {
+	r, err := w.registryClient()
+	if err != nil {
+		return err
+	}
+	_, repository, reference := splitImage(fullImage(r.Host(), w.service, w.image))
+	md, err := newMetadataCache(r).get(repository, reference)
+	if err != nil {
+		return err
+	}
+	if jsonOutput {
+		buf, _ := json.Marshal(md)
+		fmt.Fprintf(os.Stdout, "%s\n", buf)
+		return nil
+	}
+	fmt.Printf("%s\n%s", info(md.Image), md)
+	return nil
+}
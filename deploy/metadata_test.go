@@ -0,0 +1,79 @@
+package deploy
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRegistryMetadata(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v2/backend_api/manifests/1":
+			w.Header().Set("Docker-Content-Digest", "sha256:list")
+			fmt.Fprint(w, `{"mediaType": "application/vnd.docker.distribution.manifest.list.v2+json", "manifests": [
+				{"digest": "sha256:arm", "platform": {"architecture": "arm64", "os": "linux"}},
+				{"digest": "sha256:amd", "platform": {"architecture": "amd64", "os": "linux"}}]}`)
+		case "/v2/backend_api/manifests/sha256:amd":
+			w.Header().Set("Docker-Content-Digest", "sha256:amd")
+			fmt.Fprint(w, `{"mediaType": "application/vnd.docker.distribution.manifest.v2+json",
+				"config": {"digest": "sha256:cfg"}, "layers": [{"size": 100}, {"size": 23}]}`)
+		case "/v2/backend_api/blobs/sha256:cfg":
+			fmt.Fprint(w, `{"architecture": "amd64", "os": "linux", "created": "2018-06-13T15:12:11Z",
+				"config": {"Labels": {"git.branch": "master"}}}`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer ts.Close()
+
+	r, err := NewRegistry(ts.URL, "")
+	assert.Nil(t, err)
+	md, err := r.Metadata("backend_api", "1")
+	assert.Nil(t, err)
+	assert.Equal(t, "sha256:list", md.Digest)
+	assert.Equal(t, int64(123), md.Size)
+	assert.Equal(t, "amd64", md.Architecture)
+	assert.Equal(t, "master", md.Labels["git.branch"])
+	assert.Equal(t, 2018, md.Created.Year())
+}
+
+func TestMetadataCacheLookup(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v2/backend_api/manifests/1":
+			w.Header().Set("Docker-Content-Digest", "sha256:amd")
+			fmt.Fprint(w, `{"mediaType": "application/vnd.docker.distribution.manifest.v2+json",
+				"config": {"digest": "sha256:cfg"}, "layers": [{"size": 100}]}`)
+		case "/v2/backend_api/blobs/sha256:cfg":
+			fmt.Fprint(w, `{"architecture": "amd64", "os": "linux"}`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer ts.Close()
+
+	r, err := NewRegistry(ts.URL, "")
+	assert.Nil(t, err)
+	c := newMetadataCache(r)
+	c.dir = ""
+
+	// first lookup starts background fetch
+	md, err := c.lookup("backend_api", "1")
+	assert.Nil(t, err)
+	assert.Nil(t, md)
+	assert.Eventually(t, func() bool {
+		md, _ := c.lookup("backend_api", "1")
+		return md != nil && md.Digest == "sha256:amd"
+	}, time.Second, 10*time.Millisecond)
+
+	c.lookup("backend_api", "2")
+	assert.Eventually(t, func() bool {
+		_, err := c.lookup("backend_api", "2")
+		return err != nil
+	}, time.Second, 10*time.Millisecond)
+}
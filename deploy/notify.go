@@ -0,0 +1,132 @@
+package deploy
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"text/template"
+	"time"
+
+	"github.com/minus5/svckit/log"
+)
+
+// deployment events sent to webhooks
+const (
+	EventStart   = "start"
+	EventSuccess = "success"
+	EventFailure = "failure"
+)
+
+// WebhookConfig is webhook configured in deployment config.yml
+// Template is Go text/template rendered with Notification as body.
+// When template is not set Notification is sent as JSON.
+// Events limits events sent to the webhook (default all).
+type WebhookConfig struct {
+	URL         string   `yaml:"url"`
+	Template    string   `yaml:"template,omitempty"`
+	ContentType string   `yaml:"content_type,omitempty"`
+	Events      []string `yaml:"events,omitempty"`
+}
+
+func (c *WebhookConfig) wants(event string) bool {
+	if len(c.Events) == 0 {
+		return true
+	}
+	for _, e := range c.Events {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+// Notification is deployment event payload
+type Notification struct {
+	Event             string            `json:"event"`
+	Service           string            `json:"service"`
+	Deployment        string            `json:"deployment"`
+	Datacenters       []string          `json:"datacenters"`
+	OldImage          string            `json:"old_image"`
+	NewImage          string            `json:"new_image"`
+	User              string            `json:"user"`
+	Duration          float64           `json:"duration,omitempty"` // in seconds
+	NomadDeploymentID map[string]string `json:"nomad_deployment_id,omitempty"`
+	FailureReason     string            `json:"failure_reason,omitempty"`
+}
+
+type notifier struct {
+	hooks   []*WebhookConfig
+	retries int
+	backoff time.Duration
+	client  *http.Client
+}
+
+func newNotifier(hooks []*WebhookConfig) *notifier {
+	return &notifier{
+		hooks:   hooks,
+		retries: 3,
+		backoff: time.Second,
+		client:  &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// send posts notification to all webhooks interested in the event
+// Failed webhooks are only logged, they never stop deployment.
+func (n *notifier) send(m Notification) {
+	for _, h := range n.hooks {
+		if !h.wants(m.Event) {
+			continue
+		}
+		if err := n.post(h, m); err != nil {
+			log.S("url", h.URL).S("event", m.Event).Error(err)
+		}
+	}
+}
+
+func (n *notifier) post(h *WebhookConfig, m Notification) error {
+	body, err := h.body(m)
+	if err != nil {
+		return err
+	}
+	contentType := h.ContentType
+	if contentType == "" {
+		contentType = "application/json"
+	}
+	for i := 0; ; i++ {
+		err = n.postOnce(h.URL, contentType, body)
+		if err == nil || i+1 >= n.retries {
+			return err
+		}
+		log.S("url", h.URL).I("attempt", i+1).Debug("webhook failed, retrying")
+		time.Sleep(n.backoff * time.Duration(i+1))
+	}
+}
+
+func (n *notifier) postOnce(url, contentType string, body []byte) error {
+	rsp, err := n.client.Post(url, contentType, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer rsp.Body.Close()
+	if rsp.StatusCode/100 != 2 {
+		return fmt.Errorf("webhook %s responded with %s", url, rsp.Status)
+	}
+	return nil
+}
+
+// body renders webhook body for notification
+func (h *WebhookConfig) body(m Notification) ([]byte, error) {
+	if h.Template == "" {
+		return json.Marshal(m)
+	}
+	t, err := template.New(h.URL).Parse(h.Template)
+	if err != nil {
+		return nil, err
+	}
+	buf := &bytes.Buffer{}
+	if err := t.Execute(buf, m); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,35 @@
+package deploy
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNotifierRetriesAndTemplate(t *testing.T) {
+	var bodies []string
+	calls := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if calls == 1 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		buf, _ := ioutil.ReadAll(r.Body)
+		bodies = append(bodies, string(buf))
+	}))
+	defer ts.Close()
+
+	n := newNotifier([]*WebhookConfig{
+		{URL: ts.URL, Template: `{"text": "{{.Service}} {{.Event}} in {{.Deployment}}"}`},
+		{URL: ts.URL, Events: []string{EventFailure}},
+	})
+	n.backoff = 0
+	n.send(Notification{Event: EventSuccess, Service: "backend_api", Deployment: "pg1"})
+
+	assert.Equal(t, 2, calls)
+	assert.Equal(t, []string{`{"text": "backend_api success in pg1"}`}, bodies)
+}
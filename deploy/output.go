@@ -0,0 +1,128 @@
+package deploy
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+)
+
+// process exit codes for deployment failures
+const (
+	ExitFailure    = 1
+	ExitValidation = 2
+	ExitPlacement  = 3
+	ExitHealth     = 4
+	ExitGit        = 5
+	ExitCancelled  = 6
+)
+
+// jsonOutput is set when events are printed as json lines instead of text
+var jsonOutput bool
+
+// deployError is error with process exit code
+type deployError struct {
+	code int
+	err  error
+}
+
+func (e *deployError) Error() string {
+	return e.err.Error()
+}
+
+func withCode(code int, err error) error {
+	if err == nil {
+		return nil
+	}
+	if _, ok := err.(*deployError); ok {
+		return err
+	}
+	return &deployError{code: code, err: err}
+}
+
+func validationError(err error) error {
+	return withCode(ExitValidation, err)
+}
+
+func placementError(err error) error {
+	return withCode(ExitPlacement, err)
+}
+
+func healthError(err error) error {
+	return withCode(ExitHealth, err)
+}
+
+func gitError(err error) error {
+	return withCode(ExitGit, err)
+}
+
+// ExitCode returns process exit code for error
+func ExitCode(err error) int {
+	if err == nil {
+		return 0
+	}
+	if e, ok := err.(*deployError); ok {
+		return e.code
+	}
+	return ExitFailure
+}
+
+// Summary is final result of deployment printed with json output
+type Summary struct {
+	Event             string            `json:"event"`
+	Status            string            `json:"status"`
+	Service           string            `json:"service,omitempty"`
+	Deployment        string            `json:"deployment"`
+	Image             string            `json:"image,omitempty"`
+	Datacenters       []string          `json:"datacenters,omitempty"`
+	NomadDeploymentID map[string]string `json:"nomad_deployment_id,omitempty"`
+	Duration          float64           `json:"duration"`
+	Error             string            `json:"error,omitempty"`
+	ExitCode          int               `json:"exit_code"`
+}
+
+// printSummary prints deployment result as the last json event
+func (w *Worker) printSummary(started time.Time, err error) {
+	if !jsonOutput {
+		return
+	}
+	s := Summary{
+		Event:             "summary",
+		Status:            "success",
+		Service:           w.service,
+		Deployment:        w.deployment,
+		Image:             w.image,
+		NomadDeploymentID: w.nomadDepIDs,
+		Duration:          time.Since(started).Seconds(),
+		ExitCode:          ExitCode(err),
+	}
+	if w.depConfig != nil && w.service != "" {
+		s.Datacenters = w.depConfig.FindDatacenters(w.service)
+	}
+	if err != nil {
+		s.Status = "failure"
+		s.Error = err.Error()
+	}
+	buf, _ := json.Marshal(s)
+	fmt.Fprintf(os.Stdout, "%s\n", buf)
+}
+
+// writeJSON prints log line with step as json event
+// Lines without step are not events and are only written to log file.
+func (l terminalLogger) writeJSON(p []byte) (int, error) {
+	l.f.Write(p)
+	var m map[string]interface{}
+	if err := json.Unmarshal(p, &m); err != nil {
+		return len(p), nil
+	}
+	_, step := m["step"]
+	if !step && m["level"] != "error" && m["level"] != "fatal" {
+		return len(p), nil
+	}
+	for _, k := range []string{"file", "host", "app"} {
+		delete(m, k)
+	}
+	buf, _ := json.Marshal(m)
+	fmt.Fprintf(os.Stdout, "%s\n", buf)
+	return len(p), nil
+}
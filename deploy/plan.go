@@ -0,0 +1,152 @@
+package deploy
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/nomad/api"
+	"github.com/manifoldco/promptui"
+)
+
+// diff types returned by Nomad plan
+const (
+	diffTypeNone    = "None"
+	diffTypeAdded   = "Added"
+	diffTypeDeleted = "Deleted"
+	diffTypeEdited  = "Edited"
+)
+
+var edited = promptui.Styler(promptui.FGYellow)
+
+// diffMark returns colored prefix for diff type
+func diffMark(typ string) string {
+	switch typ {
+	case diffTypeAdded:
+		return success("+")
+	case diffTypeDeleted:
+		return warn("-")
+	case diffTypeEdited:
+		return edited("~")
+	}
+	return " "
+}
+
+// printPlanDiff shows field by field changes which job registration would make
+func printPlanDiff(jd *api.JobDiff) {
+	if jd == nil || jd.Type == diffTypeNone {
+		fmt.Printf("%s\n", faint("plan: no changes"))
+		return
+	}
+	fmt.Printf("%s job %q\n", diffMark(jd.Type), jd.ID)
+	printFieldDiffs(jd.Fields, 1)
+	printObjectDiffs(jd.Objects, 1)
+	for _, tg := range jd.TaskGroups {
+		if tg.Type == diffTypeNone {
+			continue
+		}
+		fmt.Printf("  %s group %q%s\n", diffMark(tg.Type), tg.Name, faint(formatUpdates(tg.Updates)))
+		printFieldDiffs(tg.Fields, 2)
+		printObjectDiffs(tg.Objects, 2)
+		for _, t := range tg.Tasks {
+			if t.Type == diffTypeNone {
+				continue
+			}
+			annotations := ""
+			if len(t.Annotations) > 0 {
+				annotations = fmt.Sprintf(" (%s)", strings.Join(t.Annotations, ", "))
+			}
+			fmt.Printf("    %s task %q%s\n", diffMark(t.Type), t.Name, faint(annotations))
+			printFieldDiffs(t.Fields, 3)
+			printObjectDiffs(t.Objects, 3)
+		}
+	}
+}
+
+func printFieldDiffs(fields []*api.FieldDiff, depth int) {
+	indent := strings.Repeat("  ", depth)
+	for _, f := range fields {
+		switch f.Type {
+		case diffTypeAdded:
+			fmt.Printf("%s%s %s: %s\n", indent, diffMark(f.Type), f.Name, success(fmt.Sprintf("%q", f.New)))
+		case diffTypeDeleted:
+			fmt.Printf("%s%s %s: %s\n", indent, diffMark(f.Type), f.Name, warn(fmt.Sprintf("%q", f.Old)))
+		case diffTypeEdited:
+			fmt.Printf("%s%s %s: %s => %s\n", indent, diffMark(f.Type), f.Name,
+				warn(fmt.Sprintf("%q", f.Old)), success(fmt.Sprintf("%q", f.New)))
+		}
+	}
+}
+
+func printObjectDiffs(objects []*api.ObjectDiff, depth int) {
+	indent := strings.Repeat("  ", depth)
+	for _, o := range objects {
+		if o.Type == diffTypeNone {
+			continue
+		}
+		fmt.Printf("%s%s %s {\n", indent, diffMark(o.Type), o.Name)
+		printFieldDiffs(o.Fields, depth+1)
+		printObjectDiffs(o.Objects, depth+1)
+		fmt.Printf("%s  }\n", indent)
+	}
+}
+
+// formatUpdates formats scheduler annotations (create, destroy, in-place update...)
+func formatUpdates(updates map[string]uint64) string {
+	if len(updates) == 0 {
+		return ""
+	}
+	var keys []string
+	for k := range updates {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var parts []string
+	for _, k := range keys {
+		parts = append(parts, fmt.Sprintf("%d %s", updates[k], k))
+	}
+	return fmt.Sprintf(" (%s)", strings.Join(parts, ", "))
+}
+
+// printPlacementFailures shows why scheduler is unable to place allocations
+func printPlacementFailures(failed map[string]*api.AllocationMetric) {
+	var groups []string
+	for k := range failed {
+		groups = append(groups, k)
+	}
+	sort.Strings(groups)
+	for _, g := range groups {
+		m := failed[g]
+		fmt.Printf("%s %s\n", promptui.IconWarn,
+			warn(fmt.Sprintf("group %q: failed to place %d allocation(s)", g, m.CoalescedFailures+1)))
+		if m.NodesEvaluated == 0 {
+			fmt.Printf("    %s\n", faint("no nodes were eligible for evaluation"))
+		}
+		for _, dc := range sortedKeys(m.NodesAvailable) {
+			if m.NodesAvailable[dc] == 0 {
+				fmt.Printf("    %s\n", faint(fmt.Sprintf("no nodes are available in datacenter %q", dc)))
+			}
+		}
+		for _, c := range sortedKeys(m.ClassFiltered) {
+			fmt.Printf("    %s\n", faint(fmt.Sprintf("class %q filtered %d nodes", c, m.ClassFiltered[c])))
+		}
+		for _, c := range sortedKeys(m.ConstraintFiltered) {
+			fmt.Printf("    %s\n", faint(fmt.Sprintf("constraint %q filtered %d nodes", c, m.ConstraintFiltered[c])))
+		}
+		if m.NodesExhausted > 0 {
+			fmt.Printf("    %s\n", faint(fmt.Sprintf("resources exhausted on %d nodes", m.NodesExhausted)))
+		}
+		for _, d := range sortedKeys(m.DimensionExhausted) {
+			fmt.Printf("    %s\n", faint(fmt.Sprintf("dimension %q exhausted on %d nodes", d, m.DimensionExhausted[d])))
+		}
+	}
+}
+
+func sortedKeys(m map[string]int) []string {
+	var keys []string
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
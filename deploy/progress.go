@@ -0,0 +1,200 @@
+package deploy
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
+
+	"github.com/chzyer/readline"
+	units "github.com/docker/go-units"
+	"github.com/hashicorp/nomad/api"
+	"github.com/mattn/go-isatty"
+)
+
+// progressEvents is number of the latest allocation events shown
+const progressEvents = 3
+
+// liveLines is number of lines of the last rendered progress table
+// Table is redrawn in place only if nothing was printed after it,
+// terminalLogger resets it on every printed log line.
+var liveLines int32
+
+// isTerminal checks if stdout is attached to a terminal
+func isTerminal() bool {
+	return isatty.IsTerminal(os.Stdout.Fd()) || isatty.IsCygwinTerminal(os.Stdout.Fd())
+}
+
+// progress renders in place updating table of deployment task groups
+type progress struct {
+	service string
+	dc      string
+	started time.Time
+	nodes   map[string]string
+}
+
+func newProgress(service, dc string) *progress {
+	return &progress{
+		service: service,
+		dc:      dc,
+		started: time.Now(),
+		nodes:   make(map[string]string),
+	}
+}
+
+// render redraws table with deployment task groups and latest events
+// Lines are truncated to terminal width, wrapped lines would break
+// redrawing in place.
+func (p *progress) render(dep *api.Deployment, events []string) {
+	width := readline.GetScreenWidth() - 1
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s %s %s\n", info(p.dc), p.service,
+		faint(truncate(fmt.Sprintf("deployment %s %s, running %s", shortID(dep.ID), dep.Status,
+			time.Since(p.started).Truncate(time.Second)), width-len(p.dc)-len(p.service)-2)))
+	rows := [][]string{{"  GROUP", "DESIRED", "PLACED", "HEALTHY", "UNHEALTHY", "CANARIES", "DEADLINE"}}
+	warned := make(map[int]bool)
+	var groups []string
+	for g := range dep.TaskGroups {
+		groups = append(groups, g)
+	}
+	sort.Strings(groups)
+	for _, g := range groups {
+		s := dep.TaskGroups[g]
+		canaries := "-"
+		if s.DesiredCanaries > 0 {
+			canaries = fmt.Sprintf("%d/%d", len(s.PlacedCanaries), s.DesiredCanaries)
+			if s.Promoted {
+				canaries += " promoted"
+			}
+		}
+		deadline := "-"
+		if !s.RequireProgressBy.IsZero() {
+			deadline = units.HumanDuration(time.Until(s.RequireProgressBy))
+		}
+		if s.UnhealthyAllocs > 0 {
+			warned[len(rows)] = true
+		}
+		rows = append(rows, []string{"  " + g,
+			strconv.Itoa(s.DesiredTotal), strconv.Itoa(s.PlacedAllocs), strconv.Itoa(s.HealthyAllocs),
+			strconv.Itoa(s.UnhealthyAllocs), canaries, deadline})
+	}
+	widths := columnWidths(rows)
+	for i, r := range rows {
+		styles := map[int]func(interface{}) string{}
+		if warned[i] {
+			styles[4] = warn
+		}
+		fmt.Fprintf(&b, "%s\n", tableRow(r, widths, styles, width))
+	}
+	for _, e := range events {
+		fmt.Fprintf(&b, "  %s\n", faint(truncate(e, width-2)))
+	}
+
+	// log lines must not be printed between clearing and redrawing the table
+	logMu.Lock()
+	defer logMu.Unlock()
+	if n := atomic.LoadInt32(&liveLines); n > 0 {
+		// move cursor to the start of the previous table and clear to the end
+		fmt.Printf("\033[%dA\033[J", n)
+	}
+	fmt.Print(b.String())
+	atomic.StoreInt32(&liveLines, int32(strings.Count(b.String(), "\n")))
+}
+
+// columnWidths returns width of the widest cell in each column
+func columnWidths(rows [][]string) []int {
+	var widths []int
+	for _, r := range rows {
+		for i, c := range r {
+			if i >= len(widths) {
+				widths = append(widths, 0)
+			}
+			if n := utf8.RuneCountInString(c); n > widths[i] {
+				widths[i] = n
+			}
+		}
+	}
+	return widths
+}
+
+// tableRow pads cells to column widths and truncates row to max width
+// Styles are applied after padding, so escape codes don't break alignment.
+// Non positive max leaves row intact.
+func tableRow(cells []string, widths []int, styles map[int]func(interface{}) string, max int) string {
+	var b strings.Builder
+	n := 0
+	for i, c := range cells {
+		if max > 0 && n >= max {
+			break
+		}
+		pad := ""
+		if i < len(cells)-1 {
+			pad = strings.Repeat(" ", widths[i]-utf8.RuneCountInString(c)+2)
+		}
+		if max > 0 {
+			c = truncate(c, max-n)
+		}
+		n += utf8.RuneCountInString(c)
+		if st, ok := styles[i]; ok {
+			c = st(c)
+		}
+		b.WriteString(c)
+		if max > 0 && n >= max {
+			break
+		}
+		if max > 0 {
+			pad = truncate(pad, max-n)
+		}
+		n += len(pad)
+		b.WriteString(pad)
+	}
+	return strings.TrimRight(b.String(), " ")
+}
+
+// truncate cuts s to max runes
+// Non positive max (unknown terminal width) leaves s intact.
+func truncate(s string, max int) string {
+	if max <= 0 || utf8.RuneCountInString(s) <= max {
+		return s
+	}
+	return string([]rune(s)[:max])
+}
+
+// latestEvents returns the newest task events of deployment allocations
+func (d *Deployer) latestEvents(depID string, nodes map[string]string) []string {
+	allocs, _, err := d.cli.Deployments().Allocations(depID, nil)
+	if err != nil {
+		return nil
+	}
+	type event struct {
+		time int64
+		text string
+	}
+	var es []event
+	for _, a := range allocs {
+		for task, s := range a.TaskStates {
+			for _, e := range s.Events {
+				msg := e.DisplayMessage
+				if msg == "" {
+					msg = e.Message
+				}
+				es = append(es, event{
+					time: e.Time,
+					text: fmt.Sprintf("%s %s %s: %s %s (%s ago)",
+						shortID(a.ID), d.nodeName(nodes, a.NodeID), task, e.Type, msg,
+						units.HumanDuration(time.Since(time.Unix(0, e.Time)))),
+				})
+			}
+		}
+	}
+	sort.Slice(es, func(i, j int) bool { return es[i].time > es[j].time })
+	var lines []string
+	for i := 0; i < len(es) && i < progressEvents; i++ {
+		lines = append(lines, es[i].text)
+	}
+	return lines
+}
@@ -0,0 +1,32 @@
+package deploy
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTableRow(t *testing.T) {
+	rows := [][]string{
+		{"  GROUP", "HEALTHY", "UNHEALTHY"},
+		{"  backend_api", "2", "1"},
+	}
+	widths := columnWidths(rows)
+	assert.Equal(t, []int{13, 7, 9}, widths)
+
+	assert.Equal(t, "  GROUP        HEALTHY  UNHEALTHY", tableRow(rows[0], widths, nil, 0))
+	assert.Equal(t, "  backend_api  2        1", tableRow(rows[1], widths, nil, 0))
+	assert.Equal(t, "  backend_api  2", tableRow(rows[1], widths, nil, 18))
+
+	red := func(v interface{}) string { return "<" + v.(string) + ">" }
+	styles := map[int]func(interface{}) string{1: red}
+	assert.Equal(t, "  backend_api  <2>        1", tableRow(rows[1], widths, styles, 0))
+	assert.Equal(t, "  backend_a", tableRow(rows[1], widths, styles, 11))
+}
+
+func TestTruncate(t *testing.T) {
+	assert.Equal(t, "abc", truncate("abc", 0))
+	assert.Equal(t, "abc", truncate("abc", 3))
+	assert.Equal(t, "ab", truncate("abc", 2))
+	assert.Equal(t, "čž", truncate("čžš", 2))
+}
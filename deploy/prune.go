@@ -0,0 +1,197 @@
+package deploy
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	units "github.com/docker/go-units"
+	"github.com/minus5/svckit/log"
+)
+
+// Prune removes old images of the service from registry
+// Images referenced in any deployment config.yml and the newest keep images
+// are protected. Without force only dry run is made.
+func (w *Worker) Prune() error {
+	steps := []func() error{
+		w.pull,
+		w.prune,
+	}
+	return runSteps(steps)
+}
+
+// pruneTag is registry tag with prune decision
+type pruneTag struct {
+	Tag
+	digest string
+	reason string // why tag is protected, empty if tag is removed
+}
+
+func (w *Worker) prune() error {
+	deployed, pinned, err := deployedTags(w.root, w.service)
+	if err != nil {
+		return err
+	}
+	r, err := w.registryClient()
+	if err != nil {
+		return err
+	}
+	names, err := r.Tags(w.service)
+	if err != nil {
+		return err
+	}
+	var ts tags
+	for _, n := range names {
+		ts = append(ts, NewTag(n, false))
+	}
+	pts := selectPrune(ts, deployed, w.keep, w.before)
+	for i, t := range pts {
+		if pts[i].digest, err = r.Digest(w.service, t.tag); err != nil {
+			return err
+		}
+	}
+	protectDigests(pts, pinned)
+
+	removed := make(map[string]bool)
+	for _, t := range pts {
+		if t.reason != "" || removed[t.digest] {
+			continue
+		}
+		if w.force {
+			if err := r.Delete(w.service, t.digest); err != nil {
+				return err
+			}
+		}
+		removed[t.digest] = true
+		log.S("step", "prune").S("service", w.service).S("tag", t.tag).S("digest", t.digest).
+			Debug("image removed")
+	}
+	if !jsonOutput {
+		printPrune(pts, w.force)
+	}
+	log.S("service", w.service).I("tags", len(pts)).I("removed", len(removed)).
+		S("dry_run", fmt.Sprintf("%v", !w.force)).Info("registry pruned")
+	return nil
+}
+
+// protectDigests keeps tags pointing to protected manifests
+// Deleting manifest removes all tags pointing to it, so manifests shared
+// with protected tags must be kept. Manifests pinned in deployments are
+// kept even if their tag was moved to another image.
+func protectDigests(pts []pruneTag, pinned map[string]bool) {
+	protected := make(map[string]string)
+	for _, t := range pts {
+		if t.reason != "" {
+			protected[t.digest] = "same image as " + t.tag
+		}
+	}
+	for d := range pinned {
+		protected[d] = "pinned in deployment"
+	}
+	for i, t := range pts {
+		if p, ok := protected[t.digest]; ok && t.reason == "" {
+			pts[i].reason = p
+		}
+	}
+}
+
+// deployedTags finds tags and pinned digests of the service referenced
+// in any deployment
+func deployedTags(root, service string) (map[string]bool, map[string]bool, error) {
+	fns, err := filepath.Glob(filepath.Join(root, "deployments", "*", "config.yml"))
+	if err != nil {
+		return nil, nil, err
+	}
+	deployed := make(map[string]bool)
+	pinned := make(map[string]bool)
+	for _, fn := range fns {
+		c, err := NewDeploymentConfig(root, filepath.Base(filepath.Dir(fn)))
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, dc := range c.Datacenters {
+			if dc == nil {
+				continue
+			}
+			s, ok := dc.Services[service]
+			if !ok || s == nil {
+				continue
+			}
+			if s.Image != "" {
+				image, digest := unpinImage(s.Image)
+				deployed[imageTag(image)] = true
+				if digest != "" {
+					pinned[digest] = true
+				}
+			}
+			if s.Digest != "" {
+				pinned[s.Digest] = true
+			}
+		}
+	}
+	return deployed, pinned, nil
+}
+
+// selectPrune decides which tags can be removed
+// Tags are protected if deployed, among the newest keep tags, created after
+// before or without build time in the tag name.
+func selectPrune(ts tags, deployed map[string]bool, keep int, before time.Time) []pruneTag {
+	sorted := make(tags, len(ts))
+	copy(sorted, ts)
+	sort.Sort(sorted)
+	var pts []pruneTag
+	for i, t := range sorted {
+		pt := pruneTag{Tag: t}
+		switch {
+		case deployed[t.tag]:
+			pt.reason = "deployed"
+		case i < keep:
+			pt.reason = "newest"
+		case t.created.IsZero():
+			pt.reason = "unknown age"
+		case t.created.After(before):
+			pt.reason = "recent"
+		}
+		pts = append(pts, pt)
+	}
+	return pts
+}
+
+func printPrune(pts []pruneTag, force bool) {
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "TAG\tAGE\tDIGEST\tACTION\n")
+	removed := 0
+	for _, t := range pts {
+		age := ""
+		if !t.created.IsZero() {
+			age = units.HumanDuration(time.Now().Sub(t.created))
+		}
+		action := faint("keep, " + t.reason)
+		if t.reason == "" {
+			action = warn("remove")
+			removed++
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", t.tag, age, shortDigest(t.digest), action)
+	}
+	tw.Flush()
+	if force {
+		fmt.Printf("%s\n", success(fmt.Sprintf("removed %d of %d tags", removed, len(pts))))
+		return
+	}
+	fmt.Printf("%s\n", info(fmt.Sprintf("dry run: %d of %d tags would be removed, use --force to remove", removed, len(pts))))
+}
+
+// shortDigest returns first 12 characters of the digest hash
+func shortDigest(digest string) string {
+	if i := strings.Index(digest, ":"); i >= 0 {
+		digest = digest[i+1:]
+	}
+	if len(digest) > 12 {
+		return digest[:12]
+	}
+	return digest
+}
@@ -0,0 +1,86 @@
+package deploy
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSelectPrune(t *testing.T) {
+	ts := tags{
+		NewTag("20180101120000.99a146a", false),
+		NewTag("20180301120000.8e1a7c2", false),
+		NewTag("20180201120000.b8a1fbf", false),
+		NewTag("20180501120000.747da38", false),
+		NewTag("20180401120000.1a2b3c4", false),
+		NewTag("latest", false),
+	}
+	deployed := map[string]bool{"20180101120000.99a146a": true}
+	before := time.Date(2018, 4, 15, 0, 0, 0, 0, time.Local)
+
+	pts := selectPrune(ts, deployed, 1, before)
+	reasons := make(map[string]string)
+	for _, t := range pts {
+		reasons[t.tag] = t.reason
+	}
+	assert.Equal(t, map[string]string{
+		"20180501120000.747da38": "newest",
+		"20180401120000.1a2b3c4": "",
+		"20180301120000.8e1a7c2": "",
+		"20180201120000.b8a1fbf": "",
+		"20180101120000.99a146a": "deployed",
+		"latest":                 "unknown age",
+	}, reasons)
+
+	pts = selectPrune(ts, deployed, 1, time.Date(2018, 2, 15, 0, 0, 0, 0, time.Local))
+	assert.Equal(t, "20180501120000.747da38", pts[0].tag)
+	assert.Equal(t, "recent", pts[1].reason)
+	assert.Equal(t, "recent", pts[2].reason)
+	assert.Equal(t, "", pts[3].reason)
+}
+
+func TestPruneMovedTag(t *testing.T) {
+	root, err := ioutil.TempDir("", "pitwall")
+	assert.Nil(t, err)
+	defer os.RemoveAll(root)
+	fn := filepath.Join(root, "deployments", "pg1", "config.yml")
+	assert.Nil(t, os.MkdirAll(filepath.Dir(fn), 0755))
+	assert.Nil(t, ioutil.WriteFile(fn, []byte(`datacenters:
+  dc1:
+    services:
+      backend_api:
+        image: registry/backend_api:20180301120000.8e1a7c2@sha256:old
+  dc2:
+    services:
+      backend_api:
+        image: registry/backend_api:20180301120000.8e1a7c2
+        digest: sha256:older
+`), 0644))
+
+	deployed, pinned, err := deployedTags(root, "backend_api")
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]bool{"20180301120000.8e1a7c2": true}, deployed)
+	assert.Equal(t, map[string]bool{"sha256:old": true, "sha256:older": true}, pinned)
+
+	// deployed tag was re-pushed, pinned manifests are only reachable by older tags
+	ts := tags{
+		NewTag("20180301120000.8e1a7c2", false),
+		NewTag("20180201120000.b8a1fbf", false),
+		NewTag("20180101120000.99a146a", false),
+		NewTag("20170101120000.747da38", false),
+	}
+	pts := selectPrune(ts, deployed, 0, time.Now())
+	digests := []string{"sha256:new", "sha256:old", "sha256:older", "sha256:unused"}
+	for i := range pts {
+		pts[i].digest = digests[i]
+	}
+	protectDigests(pts, pinned)
+	assert.Equal(t, "deployed", pts[0].reason)
+	assert.Equal(t, "pinned in deployment", pts[1].reason)
+	assert.Equal(t, "pinned in deployment", pts[2].reason)
+	assert.Equal(t, "", pts[3].reason)
+}
@@ -0,0 +1,400 @@
+package deploy
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/minus5/svckit/env"
+	"github.com/minus5/svckit/log"
+)
+
+// environment variables with registry credentials
+const (
+	RegistryUsernameEnv = "REGISTRY_USERNAME"
+	RegistryPasswordEnv = "REGISTRY_PASSWORD"
+)
+
+// Registry is Docker registry v2 API client
+// Supports basic auth and bearer token challenge flow.
+type Registry struct {
+	host     string // host[:port] used in image names
+	scheme   string
+	client   *http.Client
+	username string
+	password string
+	tokens   map[string]string // bearer token by scope
+}
+
+// registryHost strips scheme from registry url
+func registryHost(registryURL string) string {
+	if i := strings.Index(registryURL, "://"); i >= 0 {
+		return strings.TrimSuffix(registryURL[i+3:], "/")
+	}
+	return strings.TrimSuffix(registryURL, "/")
+}
+
+// NewRegistry creates registry client
+// Registry url without scheme is accessed over http, use https:// prefix for TLS.
+// caFile is optional custom CA certificate. Credentials are read from
+// REGISTRY_USERNAME and REGISTRY_PASSWORD environment variables or from
+// ~/.docker/config.json.
+func NewRegistry(registryURL, caFile string) (*Registry, error) {
+	r := &Registry{
+		host:   registryHost(registryURL),
+		scheme: "http",
+		tokens: make(map[string]string),
+	}
+	if strings.HasPrefix(registryURL, "https://") {
+		r.scheme = "https"
+	}
+	transport := &http.Transport{Proxy: http.ProxyFromEnvironment}
+	if caFile != "" {
+		pem, err := ioutil.ReadFile(env.ExpandPath(caFile))
+		if err != nil {
+			return nil, err
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", caFile)
+		}
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool}
+	}
+	r.client = &http.Client{Transport: transport, Timeout: 30 * time.Second}
+	r.username, r.password = registryCredentials(r.host)
+	return r, nil
+}
+
+// Host returns registry host used in image names
+func (r *Registry) Host() string {
+	return r.host
+}
+
+// registryCredentials finds credentials in environment or docker config
+func registryCredentials(host string) (string, string) {
+	if u := os.Getenv(RegistryUsernameEnv); u != "" {
+		return u, os.Getenv(RegistryPasswordEnv)
+	}
+	fn := filepath.Join(env.ExpandPath("~"), ".docker", "config.json")
+	data, err := ioutil.ReadFile(fn)
+	if err != nil {
+		return "", ""
+	}
+	cfg := struct {
+		Auths map[string]struct {
+			Auth string `json:"auth"`
+		} `json:"auths"`
+	}{}
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		log.S("file", fn).Error(err)
+		return "", ""
+	}
+	for k, a := range cfg.Auths {
+		if registryHost(k) != host {
+			continue
+		}
+		buf, err := base64.StdEncoding.DecodeString(a.Auth)
+		if err != nil {
+			continue
+		}
+		parts := strings.SplitN(string(buf), ":", 2)
+		if len(parts) == 2 {
+			return parts[0], parts[1]
+		}
+	}
+	return "", ""
+}
+
+// manifestMediaTypes are accepted manifest formats
+// Digest of the same format docker pulls is returned.
+var manifestMediaTypes = []string{
+	"application/vnd.docker.distribution.manifest.v2+json",
+	"application/vnd.docker.distribution.manifest.list.v2+json",
+	"application/vnd.oci.image.manifest.v1+json",
+	"application/vnd.oci.image.index.v1+json",
+}
+
+// Tags lists repository tags
+// Pages announced in Link header are followed until the last one.
+func (r *Registry) Tags(repository string) ([]string, error) {
+	var all []string
+	path := fmt.Sprintf("/v2/%s/tags/list", repository)
+	for path != "" {
+		rsp, err := r.get(path, repository)
+		if err != nil {
+			return nil, err
+		}
+		data := &struct {
+			Name string
+			Tags []string
+		}{}
+		err = json.NewDecoder(rsp.Body).Decode(data)
+		rsp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("registry %s: invalid tags response: %v", r.host, err)
+		}
+		all = append(all, data.Tags...)
+		path = nextLink(rsp.Header.Get("Link"))
+	}
+	return all, nil
+}
+
+// Digest returns content digest of the image manifest
+// Reference is tag or digest. Error is returned if image does not exist.
+func (r *Registry) Digest(repository, reference string) (string, error) {
+	header := http.Header{"Accept": manifestMediaTypes}
+	rsp, err := r.do("HEAD", fmt.Sprintf("/v2/%s/manifests/%s", repository, reference), repository, header)
+	if err != nil {
+		if e, ok := err.(*registryError); ok && e.code == http.StatusNotFound {
+			return "", fmt.Errorf("image %s:%s not found in registry %s", repository, reference, r.host)
+		}
+		return "", err
+	}
+	rsp.Body.Close()
+	digest := rsp.Header.Get("Docker-Content-Digest")
+	if digest == "" {
+		return "", fmt.Errorf("registry %s: no digest for %s:%s", r.host, repository, reference)
+	}
+	return digest, nil
+}
+
+// Delete removes image manifest from registry
+// All tags pointing to the manifest are removed. Registry must have
+// deletes enabled.
+func (r *Registry) Delete(repository, digest string) error {
+	rsp, err := r.do("DELETE", fmt.Sprintf("/v2/%s/manifests/%s", repository, digest), repository, nil)
+	if err != nil {
+		return err
+	}
+	return rsp.Body.Close()
+}
+
+// nextLink returns path of the next page from Link header
+// e.g. </v2/backend_api/tags/list?last=20180613151211&n=100>; rel="next"
+func nextLink(h string) string {
+	for _, link := range strings.Split(h, ",") {
+		parts := strings.Split(link, ";")
+		if len(parts) < 2 || !strings.Contains(parts[1], `rel="next"`) {
+			continue
+		}
+		target := strings.Trim(strings.TrimSpace(parts[0]), "<>")
+		if u, err := url.Parse(target); err == nil && u.IsAbs() {
+			return u.RequestURI()
+		}
+		return target
+	}
+	return ""
+}
+
+// splitImage splits image name into registry host, repository and reference
+// e.g. registry.dev.minus5.hr/backend_api:20180613151211.8e1a7c2
+// Host is empty for images without registry, reference is tag or digest.
+func splitImage(image string) (host, repository, reference string) {
+	name := image
+	if i := strings.Index(name, "/"); i >= 0 {
+		first := name[:i]
+		if strings.ContainsAny(first, ".:") || first == "localhost" {
+			host, name = first, name[i+1:]
+		}
+	}
+	if i := strings.Index(name, "@"); i >= 0 {
+		repository, reference = name[:i], name[i+1:]
+		if j := strings.LastIndex(repository, ":"); j >= 0 {
+			repository = repository[:j]
+		}
+		return
+	}
+	if i := strings.LastIndex(name, ":"); i >= 0 {
+		return host, name[:i], name[i+1:]
+	}
+	return host, name, "latest"
+}
+
+// pinImage appends digest to the image name
+func pinImage(image, digest string) string {
+	if digest == "" || strings.Contains(image, "@") {
+		return image
+	}
+	return image + "@" + digest
+}
+
+// unpinImage splits digest from the pinned image name
+func unpinImage(image string) (string, string) {
+	if i := strings.Index(image, "@"); i >= 0 {
+		return image[:i], image[i+1:]
+	}
+	return image, ""
+}
+
+func (r *Registry) url(path string) string {
+	return fmt.Sprintf("%s://%s%s", r.scheme, r.host, path)
+}
+
+func (r *Registry) get(path, repository string) (*http.Response, error) {
+	return r.do("GET", path, repository, nil)
+}
+
+// do sends authenticated request to registry
+// On bearer challenge token is fetched and request is repeated once.
+func (r *Registry) do(method, path, repository string, header http.Header) (*http.Response, error) {
+	scope := fmt.Sprintf("repository:%s:pull", repository)
+	if method == "DELETE" {
+		scope = fmt.Sprintf("repository:%s:pull,delete", repository)
+	}
+	for attempt := 0; ; attempt++ {
+		req, err := http.NewRequest(method, r.url(path), nil)
+		if err != nil {
+			return nil, err
+		}
+		for k, v := range header {
+			req.Header[k] = v
+		}
+		if t, ok := r.tokens[scope]; ok {
+			req.Header.Set("Authorization", "Bearer "+t)
+		} else if r.username != "" {
+			req.SetBasicAuth(r.username, r.password)
+		}
+		rsp, err := r.client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		if rsp.StatusCode == http.StatusUnauthorized && attempt == 0 {
+			challenge := rsp.Header.Get("WWW-Authenticate")
+			rsp.Body.Close()
+			if !strings.HasPrefix(strings.ToLower(challenge), "bearer ") {
+				return nil, r.statusError(http.StatusUnauthorized, repository)
+			}
+			if err := r.fetchToken(challenge, scope); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if rsp.StatusCode/100 != 2 {
+			rsp.Body.Close()
+			return nil, r.statusError(rsp.StatusCode, repository)
+		}
+		return rsp, nil
+	}
+}
+
+func (r *Registry) statusError(code int, repository string) error {
+	return &registryError{host: r.host, repository: repository, code: code}
+}
+
+// registryError is unsuccessful registry response
+type registryError struct {
+	host       string
+	repository string
+	code       int
+}
+
+func (e *registryError) Error() string {
+	switch e.code {
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return fmt.Sprintf("registry %s: access to %s denied, check credentials in ~/.docker/config.json or %s/%s",
+			e.host, e.repository, RegistryUsernameEnv, RegistryPasswordEnv)
+	case http.StatusNotFound:
+		return fmt.Sprintf("registry %s: %s not found", e.host, e.repository)
+	}
+	return fmt.Sprintf("registry %s: %s responded with %d %s", e.host, e.repository, e.code, http.StatusText(e.code))
+}
+
+// fetchToken gets bearer token from the auth server in challenge
+// Token is requested for the challenge scope if set, but cached under the
+// request scope, which do uses for lookup.
+func (r *Registry) fetchToken(challenge, scope string) error {
+	params := parseChallenge(challenge)
+	realm := params["realm"]
+	if realm == "" {
+		return fmt.Errorf("registry %s: bearer challenge without realm", r.host)
+	}
+	u, err := url.Parse(realm)
+	if err != nil {
+		return err
+	}
+	q := u.Query()
+	if s := params["service"]; s != "" {
+		q.Set("service", s)
+	}
+	requested := scope
+	if s := params["scope"]; s != "" {
+		requested = s
+	}
+	q.Set("scope", requested)
+	u.RawQuery = q.Encode()
+	req, err := http.NewRequest("GET", u.String(), nil)
+	if err != nil {
+		return err
+	}
+	if r.username != "" {
+		req.SetBasicAuth(r.username, r.password)
+	}
+	rsp, err := r.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer rsp.Body.Close()
+	if rsp.StatusCode/100 != 2 {
+		return fmt.Errorf("registry %s: token request responded with %s, check credentials in ~/.docker/config.json or %s/%s",
+			r.host, rsp.Status, RegistryUsernameEnv, RegistryPasswordEnv)
+	}
+	t := &struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}{}
+	if err := json.NewDecoder(rsp.Body).Decode(t); err != nil {
+		return err
+	}
+	if t.Token == "" {
+		t.Token = t.AccessToken
+	}
+	r.tokens[scope] = t.Token
+	return nil
+}
+
+// parseChallenge parses WWW-Authenticate header parameters
+// e.g. Bearer realm="https://auth.docker.io/token",service="registry.docker.io"
+func parseChallenge(h string) map[string]string {
+	params := make(map[string]string)
+	if i := strings.Index(h, " "); i >= 0 {
+		h = h[i+1:]
+	}
+	for len(h) > 0 {
+		h = strings.TrimLeft(h, " ,")
+		eq := strings.Index(h, "=")
+		if eq < 0 {
+			break
+		}
+		key := strings.TrimSpace(h[:eq])
+		h = h[eq+1:]
+		var value string
+		if strings.HasPrefix(h, `"`) {
+			end := strings.Index(h[1:], `"`)
+			if end < 0 {
+				value, h = h[1:], ""
+			} else {
+				value, h = h[1:end+1], h[end+2:]
+			}
+		} else {
+			end := strings.Index(h, ",")
+			if end < 0 {
+				end = len(h)
+			}
+			value, h = h[:end], h[end:]
+		}
+		params[key] = value
+	}
+	return params
+}
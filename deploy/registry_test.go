@@ -0,0 +1,147 @@
+package deploy
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRegistryBearerAuth(t *testing.T) {
+	os.Setenv(RegistryUsernameEnv, "user")
+	os.Setenv(RegistryPasswordEnv, "pass")
+	defer os.Unsetenv(RegistryUsernameEnv)
+	defer os.Unsetenv(RegistryPasswordEnv)
+
+	var ts *httptest.Server
+	ts = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/token":
+			u, p, _ := r.BasicAuth()
+			if u != "user" || p != "pass" || r.URL.Query().Get("scope") != "repository:backend_api:pull" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			fmt.Fprint(w, `{"token": "secret"}`)
+		case "/v2/backend_api/tags/list":
+			if r.Header.Get("Authorization") != "Bearer secret" {
+				w.Header().Set("WWW-Authenticate",
+					fmt.Sprintf(`Bearer realm="%s/token",service="registry",scope="repository:backend_api:pull"`, ts.URL))
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			fmt.Fprint(w, `{"name": "backend_api", "tags": ["1", "2"]}`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer ts.Close()
+
+	r, err := NewRegistry(ts.URL, "")
+	assert.Nil(t, err)
+	tags, err := r.Tags("backend_api")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"1", "2"}, tags)
+
+	_, err = r.Tags("cashier")
+	assert.Contains(t, err.Error(), "not found")
+}
+
+func TestRegistryDeleteChallengeScope(t *testing.T) {
+	var ts *httptest.Server
+	ts = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/token":
+			// challenge scope differs from the pull,delete scope of the request
+			if r.URL.Query().Get("scope") != "repository:backend_api:delete" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			fmt.Fprint(w, `{"access_token": "secret"}`)
+		case "/v2/backend_api/manifests/sha256:abc":
+			if r.Header.Get("Authorization") != "Bearer secret" {
+				w.Header().Set("WWW-Authenticate",
+					fmt.Sprintf(`Bearer realm="%s/token",service="registry",scope="repository:backend_api:delete"`, ts.URL))
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			w.WriteHeader(http.StatusAccepted)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer ts.Close()
+
+	r, err := NewRegistry(ts.URL, "")
+	assert.Nil(t, err)
+	assert.Nil(t, r.Delete("backend_api", "sha256:abc"))
+	assert.Equal(t, "secret", r.tokens["repository:backend_api:pull,delete"])
+}
+
+func TestParseChallenge(t *testing.T) {
+	p := parseChallenge(`Bearer realm="https://auth.docker.io/token",service="registry.docker.io",scope="repository:samalba/my-app:pull,push"`)
+	assert.Equal(t, "https://auth.docker.io/token", p["realm"])
+	assert.Equal(t, "registry.docker.io", p["service"])
+	assert.Equal(t, "repository:samalba/my-app:pull,push", p["scope"])
+}
+
+func TestRegistryTagsPagination(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Query().Get("last") {
+		case "":
+			w.Header().Set("Link", `</v2/backend_api/tags/list?last=2&n=2>; rel="next"`)
+			fmt.Fprint(w, `{"name": "backend_api", "tags": ["1", "2"]}`)
+		case "2":
+			w.Header().Set("Link", `</v2/backend_api/tags/list?last=4&n=2>; rel="next"`)
+			fmt.Fprint(w, `{"name": "backend_api", "tags": ["3", "4"]}`)
+		default:
+			fmt.Fprint(w, `{"name": "backend_api", "tags": ["5"]}`)
+		}
+	}))
+	defer ts.Close()
+
+	r, err := NewRegistry(ts.URL, "")
+	assert.Nil(t, err)
+	tags, err := r.Tags("backend_api")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"1", "2", "3", "4", "5"}, tags)
+}
+
+func TestRegistryDigest(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "HEAD" || r.URL.Path != "/v2/backend_api/manifests/1" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Docker-Content-Digest", "sha256:abc")
+	}))
+	defer ts.Close()
+
+	r, err := NewRegistry(ts.URL, "")
+	assert.Nil(t, err)
+	d, err := r.Digest("backend_api", "1")
+	assert.Nil(t, err)
+	assert.Equal(t, "sha256:abc", d)
+	_, err = r.Digest("backend_api", "2")
+	assert.Contains(t, err.Error(), "image backend_api:2 not found")
+}
+
+func TestSplitImage(t *testing.T) {
+	cases := []struct {
+		image, host, repository, reference string
+	}{
+		{"registry.dev.minus5.hr/backend_api:1.2", "registry.dev.minus5.hr", "backend_api", "1.2"},
+		{"localhost:5000/backend_api", "localhost:5000", "backend_api", "latest"},
+		{"minus5/backend_api:1", "", "minus5/backend_api", "1"},
+		{"registry.dev.minus5.hr/backend_api:1@sha256:abc", "registry.dev.minus5.hr", "backend_api", "sha256:abc"},
+	}
+	for _, c := range cases {
+		host, repository, reference := splitImage(c.image)
+		assert.Equal(t, c.host, host)
+		assert.Equal(t, c.repository, repository)
+		assert.Equal(t, c.reference, reference)
+	}
+}
@@ -1,23 +1,32 @@
 package deploy
 
 import (
+	"errors"
 	"fmt"
 	"os"
+	"strings"
 
 	"github.com/minus5/svckit/log"
 
 	"code.gitea.io/git"
 )
 
-// NewRepo clones repository, pulls changes
-func NewRepo(root, from string) (Repo, error) {
+// ErrPushRejected is returned when remote has changes which are not pulled
+var ErrPushRejected = errors.New("push rejected, remote contains new changes")
+
+// NewRepo clones repository, pulls changes of branch
+func NewRepo(root, from, branch string) (Repo, error) {
 	r := Repo{
-		root: root,
-		from: from,
+		root:   root,
+		from:   from,
+		branch: branch,
 	}
 	if err := r.Clone(); err != nil {
 		return r, err
 	}
+	if err := r.Checkout(); err != nil {
+		return r, err
+	}
 	if err := r.Pull(); err != nil {
 		return r, err
 	}
@@ -26,29 +35,34 @@ func NewRepo(root, from string) (Repo, error) {
 
 // Repo repository structure
 type Repo struct {
-	root string
-	from string
+	root   string
+	from   string
+	branch string
 }
 
 // Pull repository
 func (r Repo) Pull() error {
-	log.S("from", r.from).S("to", r.root).Info("pull")
+	log.S("step", "pull").S("from", r.from).S("to", r.root).S("branch", r.branch).Info("pull")
 	return git.Pull(r.root, git.PullRemoteOptions{
-		All:    true,
 		Rebase: true,
+		Remote: "origin",
+		Branch: r.branch,
 	})
 }
 
 // Push to repository
 func (r Repo) Push() error {
-	log.S("repo", r.root).Info("git push")
-	return git.Push(r.root, git.PushOptions{Remote: "origin", Branch: "master"})
+	return r.pushBranch(r.branch)
+}
 
+func (r Repo) pushBranch(branch string) error {
+	log.S("step", "push").S("repo", r.root).S("branch", branch).Info("git push")
+	return git.Push(r.root, git.PushOptions{Remote: "origin", Branch: branch})
 }
 
 // Commit to repository
 func (r Repo) Commit(msg string, files ...string) error {
-	log.S("repo", r.root).S("files", fmt.Sprintf("%v", files)).Info("git commit")
+	log.S("step", "commit").S("repo", r.root).S("files", fmt.Sprintf("%v", files)).Info("git commit")
 	if err := git.AddChanges(r.root, false, files...); err != nil {
 		return err
 	}
@@ -58,7 +72,36 @@ func (r Repo) Commit(msg string, files ...string) error {
 	if err != nil {
 		return err
 	}
-	return r.Push()
+	if err := r.Push(); err != nil {
+		if isPushRejected(err) {
+			log.S("repo", r.root).Error(err)
+			return ErrPushRejected
+		}
+		return err
+	}
+	return nil
+}
+
+// isPushRejected checks if push failed because remote is ahead
+func isPushRejected(err error) bool {
+	msg := err.Error()
+	return strings.Contains(msg, "non-fast-forward") ||
+		strings.Contains(msg, "fetch first") ||
+		strings.Contains(msg, "[rejected]")
+}
+
+// Uncommit removes the last local commit and discards its changes of file
+// Other changes in the working tree are left intact.
+func (r Repo) Uncommit(file string) error {
+	log.S("repo", r.root).S("file", file).Info("git reset")
+	if _, err := git.NewCommand("reset", "--soft", "HEAD~1").RunInDir(r.root); err != nil {
+		return err
+	}
+	if _, err := git.NewCommand("reset", "-q", "HEAD", "--", file).RunInDir(r.root); err != nil {
+		return err
+	}
+	_, err := git.NewCommand("checkout", "--", file).RunInDir(r.root)
+	return err
 }
 
 // Clone repository
@@ -67,5 +110,73 @@ func (r Repo) Clone() error {
 		return nil
 	}
 	log.S("repo", r.root).Info("git clone")
-	return git.Clone(r.from, r.root, git.CloneRepoOptions{})
+	return git.Clone(r.from, r.root, git.CloneRepoOptions{Branch: r.branch})
+}
+
+// Checkout switches existing clone to the branch
+// Clone may be on other branch when branch option changes.
+func (r Repo) Checkout() error {
+	if r.branch == "" {
+		return nil
+	}
+	out, err := git.NewCommand("rev-parse", "--abbrev-ref", "HEAD").RunInDir(r.root)
+	if err != nil {
+		return err
+	}
+	if strings.TrimSpace(out) == r.branch {
+		return nil
+	}
+	log.S("repo", r.root).S("branch", r.branch).Info("git checkout")
+	if _, err := git.NewCommand("fetch", "origin", r.branch).RunInDir(r.root); err != nil {
+		return err
+	}
+	_, err = git.NewCommand("checkout", r.branch).RunInDir(r.root)
+	return err
+}
+
+// CommitToBranch commits files to the new branch and pushes it for review
+// Repository is switched back to the base branch afterwards. Local branch
+// left from the previous attempt is reset.
+func (r Repo) CommitToBranch(branch, msg string, files ...string) error {
+	log.S("repo", r.root).S("branch", branch).Info("git checkout")
+	if _, err := git.NewCommand("checkout", "-B", branch).RunInDir(r.root); err != nil {
+		return err
+	}
+	defer func() {
+		if _, err := git.NewCommand("checkout", r.branch).RunInDir(r.root); err != nil {
+			log.S("branch", r.branch).Error(err)
+		}
+	}()
+	if err := git.AddChanges(r.root, false, files...); err != nil {
+		return err
+	}
+	err := git.CommitChanges(r.root, git.CommitChangesOptions{
+		Message: msg,
+	})
+	if err != nil {
+		return err
+	}
+	if err := r.pushBranch(branch); err != nil {
+		return err
+	}
+	log.S("branch", branch).Info("config change pushed for review")
+	return nil
+}
+
+// Log returns hashes of commits which changed file, newest first
+func (r Repo) Log(file string) ([]string, error) {
+	out, err := git.NewCommand("log", "--format=%H", "--", file).RunInDir(r.root)
+	if err != nil {
+		return nil, err
+	}
+	return strings.Fields(out), nil
+}
+
+// Show returns file content at revision
+func (r Repo) Show(rev, file string) ([]byte, error) {
+	out, err := git.NewCommand("show", fmt.Sprintf("%s:%s", rev, file)).RunInDir(r.root)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(out), nil
 }
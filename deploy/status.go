@@ -0,0 +1,183 @@
+package deploy
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	units "github.com/docker/go-units"
+	"github.com/hashicorp/nomad/api"
+	nomadStructs "github.com/hashicorp/nomad/nomad/structs"
+	"github.com/minus5/svckit/log"
+)
+
+// Status shows service allocations in all datacenters of the deployment
+func (w *Worker) Status() error {
+	steps := []func() error{
+		w.selectService,
+		w.showStatus,
+	}
+	return runSteps(steps)
+}
+
+func (w *Worker) showStatus() error {
+	dcs := w.depConfig.FindDatacenters(w.service)
+	sort.Strings(dcs)
+	for _, dc := range dcs {
+		address, err := w.nomadAddress(dc)
+		if err != nil {
+			return err
+		}
+		d := NewDeployer(w.root, w.service, "", w.depConfig, address, dc, w.deployment)
+		steps := []func() error{
+			d.loadServiceConfig,
+			d.connect,
+			d.printStatus,
+		}
+		if err := runSteps(steps); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// printStatus shows running job version, latest deployment and allocations
+func (d *Deployer) printStatus() error {
+	id := *d.job.ID
+	job, _, err := d.cli.Jobs().Info(id, nil)
+	if err != nil {
+		if jsonOutput {
+			log.S("step", "status").S("service", d.service).S("dc", d.cdc).
+				Error(fmt.Errorf("job %s not found: %v", id, err))
+			return nil
+		}
+		fmt.Printf("%s %s\n", info(d.cdc), warn(fmt.Sprintf("job %s not found: %v", id, err)))
+		return nil
+	}
+	image := ""
+	desired := 0
+	for _, tg := range job.TaskGroups {
+		if tg.Name == nil || *tg.Name != d.service {
+			continue
+		}
+		if tg.Count != nil {
+			desired = *tg.Count
+		}
+		for _, t := range tg.Tasks {
+			if t.Name == d.service {
+				image = fmt.Sprintf("%v", t.Config["image"])
+			}
+		}
+	}
+	version := uint64(0)
+	if job.Version != nil {
+		version = *job.Version
+	}
+	dep, _, err := d.cli.Jobs().LatestDeployment(id, nil)
+	if err != nil {
+		return err
+	}
+	allocs, _, err := d.cli.Jobs().Allocations(id, false, nil)
+	if err != nil {
+		return err
+	}
+	sort.Slice(allocs, func(i, j int) bool { return allocs[i].CreateTime > allocs[j].CreateTime })
+	if jsonOutput {
+		d.logStatus(version, image, desired, dep, allocs)
+		return nil
+	}
+
+	fmt.Printf("%s %s\n", info(d.cdc), faint(fmt.Sprintf("version: %d image: %s", version, image)))
+	if dep != nil {
+		status := dep.Status
+		switch status {
+		case nomadStructs.DeploymentStatusSuccessful:
+			status = success(status)
+		case nomadStructs.DeploymentStatusRunning:
+			status = info(status)
+		default:
+			status = warn(status)
+		}
+		fmt.Printf("  deployment %s %s %s\n", shortID(dep.ID), status, faint(dep.StatusDescription))
+		if s, ok := dep.TaskGroups[d.service]; ok {
+			fmt.Printf("  desired: %d placed: %d healthy: %d unhealthy: %d\n",
+				s.DesiredTotal, s.PlacedAllocs, s.HealthyAllocs, s.UnhealthyAllocs)
+		}
+	} else {
+		fmt.Printf("  desired: %d\n", desired)
+	}
+
+	nodes := make(map[string]string)
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "  ALLOC\tNODE\tVERSION\tSTATUS\tAGE\tRESTARTS\n")
+	for _, a := range allocs {
+		if a.DesiredStatus != nomadStructs.AllocDesiredStatusRun || a.TaskGroup != d.service {
+			continue
+		}
+		fmt.Fprintf(tw, "  %s\t%s\t%d\t%s\t%s\t%d\n",
+			shortID(a.ID),
+			d.nodeName(nodes, a.NodeID),
+			a.JobVersion,
+			a.ClientStatus,
+			units.HumanDuration(time.Since(time.Unix(0, a.CreateTime))),
+			restarts(a))
+	}
+	tw.Flush()
+	return nil
+}
+
+// logStatus emits job, deployment and allocations as step events for json output
+func (d *Deployer) logStatus(version uint64, image string, desired int, dep *api.Deployment, allocs []*api.AllocationListStub) {
+	l := log.S("step", "status").S("service", d.service).S("dc", d.cdc).
+		I("version", int(version)).S("image", image).I("desired", desired)
+	if dep != nil {
+		l = l.S("deploymentID", dep.ID).S("deploymentStatus", dep.Status)
+		if s, ok := dep.TaskGroups[d.service]; ok {
+			l = l.I("placed", s.PlacedAllocs).I("healthy", s.HealthyAllocs).I("unhealthy", s.UnhealthyAllocs)
+		}
+	}
+	l.Info("job status")
+	nodes := make(map[string]string)
+	for _, a := range allocs {
+		if a.DesiredStatus != nomadStructs.AllocDesiredStatusRun || a.TaskGroup != d.service {
+			continue
+		}
+		log.S("step", "status").S("service", d.service).S("dc", d.cdc).
+			S("alloc", a.ID).S("node", d.nodeName(nodes, a.NodeID)).
+			I("version", int(a.JobVersion)).S("status", a.ClientStatus).
+			I("restarts", int(restarts(a))).Info("allocation")
+	}
+}
+
+// nodeName finds Nomad node name, names are cached in nodes
+func (d *Deployer) nodeName(nodes map[string]string, id string) string {
+	if n, ok := nodes[id]; ok {
+		return n
+	}
+	n := shortID(id)
+	if node, _, err := d.cli.Nodes().Info(id, nil); err == nil {
+		n = node.Name
+	}
+	nodes[id] = n
+	return n
+}
+
+// restarts sums restarts of all allocation tasks
+func restarts(a *api.AllocationListStub) uint64 {
+	var r uint64
+	for _, s := range a.TaskStates {
+		r += s.Restarts
+	}
+	return r
+}
+
+// shortID returns first part of Nomad uuid
+func shortID(id string) string {
+	if i := strings.Index(id, "-"); i > 0 {
+		return id[:i]
+	}
+	return id
+}
@@ -0,0 +1,208 @@
+package deploy
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	yaml "gopkg.in/yaml.v3"
+)
+
+// yamlWalk follows the mapping path in yaml document
+// Returns the deepest key and value nodes found and number of path
+// elements found.
+func yamlWalk(n *yaml.Node, path ...string) (*yaml.Node, *yaml.Node, int) {
+	if n.Kind == yaml.DocumentNode && len(n.Content) > 0 {
+		n = n.Content[0]
+	}
+	var key *yaml.Node
+	value := n
+	for depth, p := range path {
+		if value.Kind != yaml.MappingNode {
+			return key, value, depth
+		}
+		found := false
+		for i := 0; i+1 < len(value.Content); i += 2 {
+			if value.Content[i].Value == p {
+				key, value = value.Content[i], value.Content[i+1]
+				found = true
+				break
+			}
+		}
+		if !found {
+			return key, value, depth
+		}
+	}
+	return key, value, len(path)
+}
+
+// yamlLookup finds key and value nodes on the mapping path in yaml document
+// Returns the deepest nodes found when path does not exist completely.
+func yamlLookup(n *yaml.Node, path ...string) (*yaml.Node, *yaml.Node) {
+	k, v, _ := yamlWalk(n, path...)
+	return k, v
+}
+
+// yamlSet sets scalar value on the mapping path in yaml document
+// Only the line with the value is changed, so comments, key order and
+// formatting of the rest of the document are preserved. Missing last key
+// is added at the end of its parent mapping.
+func yamlSet(data []byte, value string, path ...string) ([]byte, error) {
+	doc := &yaml.Node{}
+	if err := yaml.Unmarshal(data, doc); err != nil {
+		return nil, err
+	}
+	lines := strings.SplitAfter(string(data), "\n")
+	parentPath := path[:len(path)-1]
+	_, parent, found := yamlWalk(doc, parentPath...)
+	if found < len(parentPath) {
+		return nil, fmt.Errorf("%s not found", strings.Join(path[:found+1], "."))
+	}
+	if parent.Kind != yaml.MappingNode || parent.Style&yaml.FlowStyle != 0 || len(parent.Content) == 0 {
+		return nil, fmt.Errorf("%s is not a block mapping", strings.Join(parentPath, "."))
+	}
+	last := path[len(path)-1]
+	for i := 0; i+1 < len(parent.Content); i += 2 {
+		if parent.Content[i].Value == last {
+			if err := replaceScalar(lines, parent.Content[i], parent.Content[i+1], value); err != nil {
+				return nil, fmt.Errorf("%s: %v", strings.Join(path, "."), err)
+			}
+			return []byte(strings.Join(lines, "")), nil
+		}
+	}
+
+	// insert new key after the last line of the parent mapping
+	indent := strings.Repeat(" ", parent.Content[0].Column-1)
+	after, err := yamlEndLine(lines, parent)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", strings.Join(parentPath, "."), err)
+	}
+	if !strings.HasSuffix(lines[after-1], "\n") {
+		lines[after-1] += "\n"
+	}
+	line := fmt.Sprintf("%s%s: %s\n", indent, last, formatScalar(value, 0))
+	lines = append(lines[:after], append([]string{line}, lines[after:]...)...)
+	return []byte(strings.Join(lines, "")), nil
+}
+
+// yamlDelete removes key on the mapping path in yaml document
+// Lines of the key and its value are removed, the rest of the document is
+// left intact. Missing key is not an error.
+func yamlDelete(data []byte, path ...string) ([]byte, error) {
+	doc := &yaml.Node{}
+	if err := yaml.Unmarshal(data, doc); err != nil {
+		return nil, err
+	}
+	k, v, found := yamlWalk(doc, path...)
+	if found < len(path) {
+		return data, nil
+	}
+	if v.Kind != yaml.ScalarNode || k.Line != v.Line {
+		return nil, fmt.Errorf("%s: not a single line scalar", strings.Join(path, "."))
+	}
+	lines := strings.SplitAfter(string(data), "\n")
+	lines = append(lines[:k.Line-1], lines[k.Line:]...)
+	return []byte(strings.Join(lines, "")), nil
+}
+
+// replaceScalar replaces value text of the single line scalar
+func replaceScalar(lines []string, k, v *yaml.Node, value string) error {
+	if v.Kind != yaml.ScalarNode || v.Style&(yaml.LiteralStyle|yaml.FoldedStyle) != 0 {
+		return fmt.Errorf("not a single line scalar")
+	}
+	if v.Value == "" && v.Style == 0 {
+		// empty value, write after the key colon
+		i := k.Line - 1
+		line := lines[i]
+		c := k.Column - 1 + strings.Index(line[k.Column-1:], ":") + 1
+		lines[i] = line[:c] + " " + formatScalar(value, 0) + line[commentStart(line, c):]
+		return nil
+	}
+	i := v.Line - 1
+	line := lines[i]
+	start := v.Column - 1
+	end := scalarEnd(line, start, v.Style)
+	lines[i] = line[:start] + formatScalar(value, v.Style) + line[end:]
+	return nil
+}
+
+// scalarEnd finds end of scalar starting at start in line
+func scalarEnd(line string, start int, style yaml.Style) int {
+	switch {
+	case style&yaml.DoubleQuotedStyle != 0:
+		for i := start + 1; i < len(line); i++ {
+			if line[i] == '\\' {
+				i++
+				continue
+			}
+			if line[i] == '"' {
+				return i + 1
+			}
+		}
+	case style&yaml.SingleQuotedStyle != 0:
+		for i := start + 1; i < len(line); i++ {
+			if line[i] != '\'' {
+				continue
+			}
+			if i+1 < len(line) && line[i+1] == '\'' {
+				i++
+				continue
+			}
+			return i + 1
+		}
+	}
+	end := commentStart(line, start)
+	return start + len(strings.TrimRight(line[start:end], " \t"))
+}
+
+// commentStart returns position of the line comment or line end
+func commentStart(line string, from int) int {
+	end := len(strings.TrimRight(line, "\r\n"))
+	for i := from; i < end; i++ {
+		if line[i] == '#' && (i == 0 || line[i-1] == ' ' || line[i-1] == '\t') {
+			return i
+		}
+	}
+	return end
+}
+
+// formatScalar formats value keeping the quoting style
+// Plain values which would not be read back as the same string are quoted.
+func formatScalar(value string, style yaml.Style) string {
+	switch {
+	case style&yaml.DoubleQuotedStyle != 0:
+		return strconv.Quote(value)
+	case style&yaml.SingleQuotedStyle != 0:
+		return "'" + strings.Replace(value, "'", "''", -1) + "'"
+	}
+	var m map[string]interface{}
+	if err := yaml.Unmarshal([]byte("v: "+value), &m); err == nil && fmt.Sprint(m["v"]) == value {
+		return value
+	}
+	return strconv.Quote(value)
+}
+
+// yamlEndLine returns the last line of the block mapping
+// Node line is the first line of block scalars and flow collections, so
+// lines are followed while they are indented deeper than mapping keys.
+// Trailing blank lines are not part of the mapping.
+func yamlEndLine(lines []string, mapping *yaml.Node) (int, error) {
+	last := mapping.Content[len(mapping.Content)-2]
+	indent := last.Column - 1
+	end := last.Line
+	for i := end; i < len(lines); i++ {
+		l := strings.TrimRight(lines[i], "\r\n")
+		t := strings.TrimLeft(l, " ")
+		if t == "" {
+			continue
+		}
+		if len(l)-len(t) <= indent {
+			if strings.HasPrefix(t, "]") || strings.HasPrefix(t, "}") {
+				return 0, fmt.Errorf("ends with multi-line flow collection")
+			}
+			break
+		}
+		end = i + 1
+	}
+	return end, nil
+}
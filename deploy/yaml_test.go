@@ -0,0 +1,137 @@
+package deploy
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testConfig = `federated_dcs: dc1 dc2
+datacenters:
+  dc1:
+    services:
+      # backend api
+      backend_api:
+        image: registry/backend_api:1 # deployed by pitwall
+        count: 2
+      cashier:
+        image: "registry/cashier:1"
+        mem: 256
+`
+
+func TestYamlSet(t *testing.T) {
+	data, err := yamlSet([]byte(testConfig), "registry/backend_api:2", "datacenters", "dc1", "services", "backend_api", "image")
+	assert.Nil(t, err)
+	assert.Equal(t, `federated_dcs: dc1 dc2
+datacenters:
+  dc1:
+    services:
+      # backend api
+      backend_api:
+        image: registry/backend_api:2 # deployed by pitwall
+        count: 2
+      cashier:
+        image: "registry/cashier:1"
+        mem: 256
+`, string(data))
+
+	data, err = yamlSet(data, "registry/cashier:2", "datacenters", "dc1", "services", "cashier", "image")
+	assert.Nil(t, err)
+	data, err = yamlSet(data, "3", "datacenters", "dc1", "services", "cashier", "count")
+	assert.Nil(t, err)
+	assert.Equal(t, `federated_dcs: dc1 dc2
+datacenters:
+  dc1:
+    services:
+      # backend api
+      backend_api:
+        image: registry/backend_api:2 # deployed by pitwall
+        count: 2
+      cashier:
+        image: "registry/cashier:2"
+        mem: 256
+        count: 3
+`, string(data))
+
+	_, err = yamlSet(data, "1", "datacenters", "dc2", "services", "cashier", "count")
+	assert.NotNil(t, err)
+}
+
+func TestYamlDelete(t *testing.T) {
+	data, err := yamlSet([]byte(testConfig), "sha256:4f2a", "datacenters", "dc1", "services", "backend_api", "digest")
+	assert.Nil(t, err)
+	assert.Contains(t, string(data), "        digest: sha256:4f2a\n")
+
+	data, err = yamlDelete(data, "datacenters", "dc1", "services", "backend_api", "digest")
+	assert.Nil(t, err)
+	assert.Equal(t, testConfig, string(data))
+
+	// missing key is left alone
+	data, err = yamlDelete(data, "datacenters", "dc1", "services", "cashier", "digest")
+	assert.Nil(t, err)
+	assert.Equal(t, testConfig, string(data))
+
+	_, err = yamlDelete(data, "datacenters", "dc1", "services", "cashier")
+	assert.NotNil(t, err)
+}
+
+func TestSetEmptyDigest(t *testing.T) {
+	c := &DeploymentConfig{data: []byte(`datacenters:
+  dc1:
+    services:
+      backend_api:
+        image: registry/backend_api:1
+        digest: sha256:4f2a
+        count: 2
+`)}
+	c.Datacenters = map[string]*DcConfig{"dc1": {Services: map[string]*ServiceConfig{
+		"backend_api": {Image: "registry/backend_api:1", Digest: "sha256:4f2a"},
+	}}}
+	assert.Nil(t, c.Set("dc1", "backend_api", "digest", ""))
+	assert.Equal(t, `datacenters:
+  dc1:
+    services:
+      backend_api:
+        image: registry/backend_api:1
+        count: 2
+`, string(c.data))
+	assert.Equal(t, "", c.FindForDc("backend_api", "dc1").Digest)
+}
+
+func TestYamlSetAfterBlockScalar(t *testing.T) {
+	config := `datacenters:
+  dc1:
+    services:
+      backend_api:
+        image: registry/backend_api:1
+        args: |
+          --port 8080
+
+          --verbose
+      cashier:
+        image: registry/cashier:1
+`
+	data, err := yamlSet([]byte(config), "sha256:4f2a", "datacenters", "dc1", "services", "backend_api", "digest")
+	assert.Nil(t, err)
+	assert.Equal(t, `datacenters:
+  dc1:
+    services:
+      backend_api:
+        image: registry/backend_api:1
+        args: |
+          --port 8080
+
+          --verbose
+        digest: sha256:4f2a
+      cashier:
+        image: registry/cashier:1
+`, string(data))
+
+	_, err = yamlSet([]byte(`services:
+  backend_api:
+    args: [
+      --port,
+    ]
+`), "1", "services", "backend_api", "count")
+	assert.NotNil(t, err)
+}
@@ -104,6 +104,21 @@ func tail(o TailOptions) error {
 	return nil
 }
 
+// Subscribe reads service log stream and calls handler for each log line
+// Stream is read in background until returned closer is closed.
+func Subscribe(address, service string, handler func([]byte) error) (io.Closer, error) {
+	rsp, err := http.Get(TailOptions{Address: address, Service: service}.logsUrl())
+	if err != nil {
+		return nil, err
+	}
+	if rsp.StatusCode != http.StatusOK {
+		rsp.Body.Close()
+		return nil, fmt.Errorf("log stream for %s responded with %s", service, rsp.Status)
+	}
+	go readSse(rsp.Body, handler)
+	return rsp.Body, nil
+}
+
 func readSse(body io.ReadCloser, lineHanlder func([]byte) error) error {
 	defer body.Close()
 